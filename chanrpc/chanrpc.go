@@ -1,9 +1,11 @@
 package chanrpc
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"runtime"
+	"time"
 
 	"github.com/jiangzuomin/leaf/config"
 	"github.com/jiangzuomin/leaf/log"
@@ -17,16 +19,24 @@ type Server struct {
 	// func(args []interface{})
 	// func(args []interface{}) interface{}
 	// func(args []interface{}) []interface{}
+	// func(ctx context.Context, args []interface{}) (interface{}, error)
 	functions map[interface{}]interface{}		// 注册函数映射
 	ChanCall  chan *CallInfo					// 异步掉用一次性最多能传递多少函数
 }
 
 // 函数调用信息
 type CallInfo struct {
+	id      interface{}			// 函数 id
 	f       interface{}			// 函数
 	args    []interface{}		// 函数参数
 	chanRet chan *RetInfo		// 函数返回
 	cb      interface{}			// 回调函数
+	ctx     context.Context		// 调用上下文, 可为 nil
+}
+
+// ID returns the id the function is registered with
+func (ci *CallInfo) ID() interface{} {
+	return ci.id
 }
 
 // 函数返回信息
@@ -63,6 +73,7 @@ func (s *Server) Register(id interface{}, fc interface{}) {
 	case func([]interface{}):
 	case func([]interface{}) interface{}:
 	case func([]interface{}) []interface{}:
+	case func(context.Context, []interface{}) (interface{}, error):
 	default:
 		log.Log.WithFields(logrus.Fields{"func id": id}).Fatal("definition of function is invalid")
 	}
@@ -92,6 +103,11 @@ func (s *Server) ret(ci *CallInfo, ri *RetInfo) (err error) {
 }
 
 func (s *Server) exec(ci *CallInfo) (err error) {
+	// 调用方已超时或取消, 不再执行
+	if ci.ctx != nil && ci.ctx.Err() != nil {
+		return s.ret(ci, &RetInfo{err: ci.ctx.Err()})
+	}
+
 	defer func() {
 		if r := recover(); r != nil {
 			if config.LenStackBuf > 0 {
@@ -115,6 +131,13 @@ func (s *Server) exec(ci *CallInfo) (err error) {
 	case func([]interface{}) []interface{}:
 		ret := ci.f.(func([]interface{}) []interface{})(ci.args)
 		return s.ret(ci, &RetInfo{ret: ret})
+	case func(context.Context, []interface{}) (interface{}, error):
+		ctx := ci.ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		ret, err := ci.f.(func(context.Context, []interface{}) (interface{}, error))(ctx, ci.args)
+		return s.ret(ci, &RetInfo{ret: ret, err: err})
 	}
 
 	log.Log.Panic("bug")
@@ -139,6 +162,7 @@ func (s *Server) Go(id interface{}, args ...interface{}) {
 	}()
 
 	s.ChanCall <- &CallInfo{
+		id:   id,
 		f:    fc,
 		args: args,
 	}
@@ -165,6 +189,21 @@ func (s *Server) CallN(id interface{}, args ...interface{}) ([]interface{}, erro
 	return s.Open(0).CallN(id, args...)
 }
 
+// goroutine safe
+func (s *Server) Call0Context(ctx context.Context, id interface{}, args ...interface{}) error {
+	return s.Open(0).Call0Context(ctx, id, args...)
+}
+
+// goroutine safe
+func (s *Server) Call1Context(ctx context.Context, id interface{}, args ...interface{}) (interface{}, error) {
+	return s.Open(0).Call1Context(ctx, id, args...)
+}
+
+// goroutine safe
+func (s *Server) CallNContext(ctx context.Context, id interface{}, args ...interface{}) ([]interface{}, error) {
+	return s.Open(0).CallNContext(ctx, id, args...)
+}
+
 func (s *Server) Close() {
 	close(s.ChanCall)
 
@@ -207,6 +246,23 @@ func (c *Client) call(ci *CallInfo, block bool) (err error) {
 	return
 }
 
+// 阻塞发送调用信息, 直到成功或 ctx 结束
+func (c *Client) callContext(ctx context.Context, ci *CallInfo) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = r.(error)
+		}
+	}()
+
+	select {
+	case c.s.ChanCall <- ci:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	return
+}
+
 func (c *Client) f(id interface{}, n int) (f interface{}, err error) {
 	if c.s == nil {
 		err = errors.New("server not attached")
@@ -225,7 +281,12 @@ func (c *Client) f(id interface{}, n int) (f interface{}, err error) {
 	case 0:
 		_, ok = f.(func([]interface{}))
 	case 1:
-		_, ok = f.(func([]interface{}) interface{})
+		switch f.(type) {
+		case func([]interface{}) interface{}:
+			ok = true
+		case func(context.Context, []interface{}) (interface{}, error):
+			ok = true
+		}
 	case 2:
 		_, ok = f.(func([]interface{}) []interface{})
 	default:
@@ -245,6 +306,7 @@ func (c *Client) Call0(id interface{}, args ...interface{}) error {
 	}
 
 	err = c.call(&CallInfo{
+		id:      id,
 		f:       f,
 		args:    args,
 		chanRet: c.chanSyncRet,
@@ -266,6 +328,7 @@ func (c *Client) Call1(id interface{}, args ...interface{}) (interface{}, error)
 	}
 
 	err = c.call(&CallInfo{
+		id:      id,
 		f:       f,
 		args:    args,
 		chanRet: c.chanSyncRet,
@@ -287,6 +350,7 @@ func (c *Client) CallN(id interface{}, args ...interface{}) ([]interface{}, erro
 	}
 
 	err = c.call(&CallInfo{
+		id:      id,
 		f:       f,
 		args:    args,
 		chanRet: c.chanSyncRet,
@@ -301,6 +365,61 @@ func (c *Client) CallN(id interface{}, args ...interface{}) ([]interface{}, erro
 	return assert(ri.ret), ri.err
 }
 
+// 每次调用使用独立的返回 channel, 超时后迟到的返回值会被丢弃, 不会被下一次调用读到
+func (c *Client) syncCallContext(ctx context.Context, id interface{}, args []interface{}, n int) (*RetInfo, error) {
+	f, err := c.f(id, n)
+	if err != nil {
+		return nil, err
+	}
+
+	chanRet := make(chan *RetInfo, 1)
+	err = c.callContext(ctx, &CallInfo{
+		id:      id,
+		f:       f,
+		args:    args,
+		chanRet: chanRet,
+		ctx:     ctx,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case ri := <-chanRet:
+		return ri, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *Client) Call0Context(ctx context.Context, id interface{}, args ...interface{}) error {
+	ri, err := c.syncCallContext(ctx, id, args, 0)
+	if err != nil {
+		return err
+	}
+
+	return ri.err
+}
+
+func (c *Client) Call1Context(ctx context.Context, id interface{}, args ...interface{}) (interface{}, error) {
+	ri, err := c.syncCallContext(ctx, id, args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return ri.ret, ri.err
+}
+
+func (c *Client) CallNContext(ctx context.Context, id interface{}, args ...interface{}) ([]interface{}, error) {
+	ri, err := c.syncCallContext(ctx, id, args, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	return assert(ri.ret), ri.err
+}
+
 func (c *Client) asyncCall(id interface{}, args []interface{}, cb interface{}, n int) {
 	f, err := c.f(id, n)
 	if err != nil {
@@ -309,6 +428,7 @@ func (c *Client) asyncCall(id interface{}, args []interface{}, cb interface{}, n
 	}
 
 	err = c.call(&CallInfo{
+		id:      id,
 		f:       f,
 		args:    args,
 		chanRet: c.ChanAsynRet,
@@ -329,7 +449,19 @@ func (c *Client) AsynCall(id interface{}, _args ...interface{}) {
 	args := _args[:len(_args)-1]
 	cb := _args[len(_args)-1]
 
-	var n int
+	n := cbRetN(cb)
+
+	if c.PendingAsynCall >= cap(c.ChanAsynRet) {
+		execCb(&RetInfo{err: errors.New("too many calls"), cb: cb})
+		return
+	}
+
+	c.asyncCall(id, args, cb, n)
+	c.PendingAsynCall++
+}
+
+// 回调函数对应的返回值类型: 0 无返回值, 1 单个返回值, 2 多个返回值
+func cbRetN(cb interface{}) (n int) {
 	switch cb.(type) {
 	case func(error):
 		n = 0
@@ -340,14 +472,86 @@ func (c *Client) AsynCall(id interface{}, _args ...interface{}) {
 	default:
 		log.Log.Fatal("definition of callback function is invalid")
 	}
+	return
+}
+
+// AsynCallFunc starts an asynchronous call that is served outside the attached
+// server (e.g. on a remote node). call receives the return type expected by cb
+// and must invoke done exactly once; done is goroutine safe and delivers the
+// result through ChanAsynRet like any other AsynCall.
+func (c *Client) AsynCallFunc(call func(n int, done func(ret interface{}, err error)), cb interface{}) {
+	n := cbRetN(cb)
 
 	if c.PendingAsynCall >= cap(c.ChanAsynRet) {
 		execCb(&RetInfo{err: errors.New("too many calls"), cb: cb})
 		return
 	}
 
-	c.asyncCall(id, args, cb, n)
 	c.PendingAsynCall++
+	call(n, func(ret interface{}, err error) {
+		c.ChanAsynRet <- &RetInfo{ret: ret, err: err, cb: cb}
+	})
+}
+
+// AsynCallContext is like AsynCall, but the callback receives ctx.Err() once
+// ctx is done before the server returns. The call is then no longer pending and
+// a late return value is discarded.
+func (c *Client) AsynCallContext(ctx context.Context, id interface{}, _args ...interface{}) {
+	c.asynCallContext(ctx, nil, id, _args)
+}
+
+// AsynCallTimeout is like AsynCallContext with a deadline of d from now.
+func (c *Client) AsynCallTimeout(d time.Duration, id interface{}, _args ...interface{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	c.asynCallContext(ctx, cancel, id, _args)
+}
+
+func (c *Client) asynCallContext(ctx context.Context, cancel context.CancelFunc, id interface{}, _args []interface{}) {
+	if len(_args) < 1 {
+		log.Log.Fatal("callback function not found")
+	}
+
+	args := _args[:len(_args)-1]
+	cb := _args[len(_args)-1]
+
+	c.AsynCallFunc(func(n int, done func(interface{}, error)) {
+		finish := func(ret interface{}, err error) {
+			if cancel != nil {
+				cancel()
+			}
+			done(ret, err)
+		}
+
+		f, err := c.f(id, n)
+		if err != nil {
+			finish(nil, err)
+			return
+		}
+
+		// 服务端返回到独立的 channel, 由转发 goroutine 保证只向 ChanAsynRet 投递一次
+		chanRet := make(chan *RetInfo, 1)
+		err = c.call(&CallInfo{
+			id:      id,
+			f:       f,
+			args:    args,
+			chanRet: chanRet,
+			ctx:     ctx,
+		}, false)
+
+		if err != nil {
+			finish(nil, err)
+			return
+		}
+
+		go func() {
+			select {
+			case ri := <-chanRet:
+				finish(ri.ret, ri.err)
+			case <-ctx.Done():
+				finish(nil, ctx.Err())
+			}
+		}()
+	}, cb)
 }
 
 func execCb(ri *RetInfo) {
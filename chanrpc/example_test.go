@@ -1,9 +1,11 @@
 package chanrpc
 
 import (
+	"context"
 	"github.com/jiangzuomin/leaf/log"
 	"sync"
 	"testing"
+	"time"
 )
 
 func TestChanrpc(t *testing.T) {
@@ -117,3 +119,106 @@ func TestChanrpc(t *testing.T) {
 
 	wg.Wait()
 }
+
+func TestCallContext(t *testing.T) {
+	s := NewServer(10)
+
+	block := make(chan struct{})
+	s.Register("slow", func(args []interface{}) interface{} {
+		<-block
+		return 1
+	})
+	s.Register("fast", func(args []interface{}) interface{} {
+		return 2
+	})
+
+	go func() {
+		for ci := range s.ChanCall {
+			s.Exec(ci)
+		}
+	}()
+
+	c := s.Open(10)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.Call1Context(ctx, "slow"); err != context.DeadlineExceeded {
+		t.Fatalf("slow: expected deadline exceeded, got %v", err)
+	}
+
+	var n int
+	c.AsynCallTimeout(10*time.Millisecond, "slow", func(ret interface{}, err error) {
+		n++
+		if err != context.DeadlineExceeded {
+			t.Fatalf("asyn slow: expected deadline exceeded, got %v", err)
+		}
+	})
+	c.Cb(<-c.ChanAsynRet)
+	if n != 1 || !c.Idle() {
+		t.Fatalf("asyn slow: callback count %v, pending %v", n, c.PendingAsynCall)
+	}
+
+	// late replies must neither reach the next call nor the callback
+	close(block)
+	ret, err := c.Call1Context(context.Background(), "fast")
+	if err != nil || ret.(int) != 2 {
+		t.Fatalf("fast: %v %v", ret, err)
+	}
+	select {
+	case ri := <-c.ChanAsynRet:
+		t.Fatalf("unexpected late reply %v", ri)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestMethod(t *testing.T) {
+	type AddReq struct{ A, B int }
+	type AddResp struct{ Sum int }
+
+	add := NewMethod[*AddReq, *AddResp]("add")
+	wrong := NewMethod[*AddReq, string]("add")
+
+	s := NewServer(10)
+	add.Register(s, func(ctx context.Context, req *AddReq) (*AddResp, error) {
+		return &AddResp{Sum: req.A + req.B}, nil
+	})
+	s.Register("untyped", func(args []interface{}) interface{} {
+		return 1
+	})
+
+	go func() {
+		for ci := range s.ChanCall {
+			s.Exec(ci)
+		}
+	}()
+
+	c := s.Open(10)
+
+	resp, err := add.Call(context.Background(), c, &AddReq{A: 1, B: 2})
+	if err != nil || resp.Sum != 3 {
+		t.Fatalf("add: %v %v", resp, err)
+	}
+
+	// untyped call to a typed function
+	ret, err := c.Call1("add", &AddReq{A: 2, B: 2})
+	if err != nil || ret.(*AddResp).Sum != 4 {
+		t.Fatalf("untyped add: %v %v", ret, err)
+	}
+	if _, err := c.Call1("add", 1, 2); err == nil {
+		t.Fatal("untyped add: argument type mismatch expected")
+	}
+
+	if _, err := wrong.Call(context.Background(), c, &AddReq{}); err == nil {
+		t.Fatal("wrong: return type mismatch expected")
+	}
+	if _, err := NewMethod[int, string]("untyped").Call(context.Background(), c, 0); err == nil {
+		t.Fatal("untyped: return type mismatch expected")
+	}
+
+	add.AsynCall(context.Background(), c, &AddReq{A: 3, B: 4}, func(resp *AddResp, err error) {
+		if err != nil || resp.Sum != 7 {
+			t.Fatalf("asyn add: %v %v", resp, err)
+		}
+	})
+	c.Cb(<-c.ChanAsynRet)
+}
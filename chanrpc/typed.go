@@ -0,0 +1,69 @@
+package chanrpc
+
+import (
+	"context"
+	"fmt"
+)
+
+// Method is a typed chanrpc function id. The request and response types are
+// checked at compile time on both the registering and the calling side, while
+// the calls still go through Server.ChanCall, so typed and untyped functions
+// can be mixed freely on the same server.
+//
+// Untyped clients can call a typed function with Call1(id, req), typed calls
+// to an untyped func([]interface{}) interface{} report a type mismatch as an
+// error instead of panicking.
+type Method[Req, Resp any] struct {
+	ID interface{}
+}
+
+func NewMethod[Req, Resp any](id interface{}) Method[Req, Resp] {
+	return Method[Req, Resp]{ID: id}
+}
+
+// you must call the function before calling the method (same as Server.Register)
+func (m Method[Req, Resp]) Register(s *Server, f func(context.Context, Req) (Resp, error)) {
+	s.Register(m.ID, func(ctx context.Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("function id %v: expected 1 argument, got %v", m.ID, len(args))
+		}
+		req, ok := args[0].(Req)
+		if !ok {
+			return nil, fmt.Errorf("function id %v: argument type mismatch: %T", m.ID, args[0])
+		}
+		return f(ctx, req)
+	})
+}
+
+func (m Method[Req, Resp]) resp(ret interface{}, err error) (Resp, error) {
+	var resp Resp
+	if err != nil {
+		return resp, err
+	}
+	if ret == nil {
+		return resp, nil
+	}
+	resp, ok := ret.(Resp)
+	if !ok {
+		return resp, fmt.Errorf("function id %v: return type mismatch: %T", m.ID, ret)
+	}
+	return resp, nil
+}
+
+// goroutine safe
+func (m Method[Req, Resp]) Go(s *Server, req Req) {
+	s.Go(m.ID, req)
+}
+
+// goroutine not safe (same as Client)
+func (m Method[Req, Resp]) Call(ctx context.Context, c *Client, req Req) (Resp, error) {
+	return m.resp(c.Call1Context(ctx, m.ID, req))
+}
+
+// goroutine not safe (same as Client)
+// cb is executed through c.ChanAsynRet
+func (m Method[Req, Resp]) AsynCall(ctx context.Context, c *Client, req Req, cb func(Resp, error)) {
+	c.AsynCallContext(ctx, m.ID, req, func(ret interface{}, err error) {
+		cb(m.resp(ret, err))
+	})
+}
@@ -1,18 +1,33 @@
 package cluster
 
 import (
-	 "github.com/jiangzuomin/leaf/config"
-	 "github.com/jiangzuomin/leaf/network"
-	 "math"
-	 "time"
+	"errors"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jiangzuomin/leaf/config"
+	"github.com/jiangzuomin/leaf/log"
+	"github.com/jiangzuomin/leaf/network"
+	"github.com/sirupsen/logrus"
 )
 
 var (
 	server  *network.TCPServer
 	clients []*network.TCPClient
+
+	// node name -> agent
+	agents      = make(map[string]*Agent)
+	mutexAgents sync.RWMutex
 )
 
 func Init() {
+	// 对端在握手时拒绝空的节点名
+	if config.NodeName == "" && (config.ListenAddr != "" || len(config.ConnAddrs) > 0) {
+		log.Log.Fatal("cluster requires config.NodeName")
+	}
+
 	if config.ListenAddr != "" {
 		server = new(network.TCPServer)
 		server.Addr = config.ListenAddr
@@ -26,18 +41,32 @@ func Init() {
 	}
 
 	for _, addr := range config.ConnAddrs {
-		client := new(network.TCPClient)
-		client.Addr = addr
-		client.ConnNum = 1
-		client.ConnectInterval = 3 * time.Second
-		client.PendingWriteNum = config.PendingWriteNum
-		client.LenMsgLen = 4
-		client.MaxMsgLen = math.MaxUint32
-		client.NewAgent = newAgent
-
-		client.Start()
-		clients = append(clients, client)
+		connect(addr)
+	}
+}
+
+// ListenAddr returns the address the cluster server listens on, nil if it
+// does not listen
+func ListenAddr() net.Addr {
+	if server == nil {
+		return nil
 	}
+	return server.ListenAddr()
+}
+
+func connect(addr string) {
+	client := new(network.TCPClient)
+	client.Addr = addr
+	client.ConnNum = 1
+	client.ConnectInterval = 3 * time.Second
+	client.PendingWriteNum = config.PendingWriteNum
+	client.AutoReconnect = true
+	client.LenMsgLen = 4
+	client.MaxMsgLen = math.MaxUint32
+	client.NewAgent = newAgent
+
+	client.Start()
+	clients = append(clients, client)
 }
 
 func Destroy() {
@@ -48,18 +77,166 @@ func Destroy() {
 	for _, client := range clients {
 		client.Close()
 	}
+
+	server = nil
+	clients = nil
 }
 
 type Agent struct {
 	conn *network.TCPConn
+	node string
+
+	// seq -> reply
+	seq          uint32
+	pending      map[uint32]*pendingCall
+	mutexPending sync.Mutex
+	closeFlag    bool
+}
+
+// 等待应答的调用
+type pendingCall struct {
+	chanRet chan *message
+	timer   *time.Timer // config.ClusterCallTimeout 为 0 时为 nil
 }
 
 func newAgent(conn *network.TCPConn) network.Agent {
 	a := new(Agent)
 	a.conn = conn
+	a.pending = make(map[uint32]*pendingCall)
 	return a
 }
 
-func (a *Agent) Run() {}
+func (a *Agent) Run() {
+	err := a.write(&message{Type: msgHello, Node: config.NodeName})
+	if err != nil {
+		log.Log.WithField("Err", err).Error("cluster hello")
+		return
+	}
+
+	for {
+		data, err := a.conn.ReadMsg()
+		if err != nil {
+			log.Log.WithFields(logrus.Fields{"Node": a.node, "Err": err}).Debug("read cluster message")
+			break
+		}
+
+		m, err := unmarshal(data)
+		if err != nil {
+			log.Log.WithFields(logrus.Fields{"Node": a.node, "Err": err}).Error("unmarshal cluster message")
+			break
+		}
+
+		switch m.Type {
+		case msgHello:
+			a.onHello(m)
+		case msgRequest:
+			a.onRequest(m)
+		case msgResponse:
+			a.onResponse(m)
+		default:
+			log.Log.WithField("Type", m.Type).Error("invalid cluster message")
+		}
+	}
+}
+
+func (a *Agent) OnClose() {
+	if a.node != "" {
+		mutexAgents.Lock()
+		if agents[a.node] == a {
+			delete(agents, a.node)
+		}
+		mutexAgents.Unlock()
+	}
+
+	a.mutexPending.Lock()
+	a.closeFlag = true
+	pending := a.pending
+	a.pending = make(map[uint32]*pendingCall)
+	a.mutexPending.Unlock()
+
+	for _, c := range pending {
+		if c.timer != nil {
+			c.timer.Stop()
+		}
+		c.chanRet <- &message{Type: msgResponse, Err: "cluster node closed"}
+	}
+}
+
+func (a *Agent) onHello(m *message) {
+	if m.Node == "" {
+		log.Log.WithField("Addr", a.conn.RemoteAddr()).Error("cluster node without name")
+		return
+	}
+
+	a.node = m.Node
+	mutexAgents.Lock()
+	agents[a.node] = a
+	mutexAgents.Unlock()
+
+	log.Log.WithFields(logrus.Fields{"Node": a.node, "Addr": a.conn.RemoteAddr()}).Info("cluster node connected")
+}
+
+func (a *Agent) write(m *message) error {
+	data, err := marshal(m)
+	if err != nil {
+		return err
+	}
+	return a.conn.WriteMsg(data)
+}
+
+// goroutine safe
+// 发送请求, 返回接收应答的 channel
+func (a *Agent) request(m *message) (chan *message, error) {
+	chanRet := make(chan *message, 1)
+
+	a.mutexPending.Lock()
+	if a.closeFlag {
+		a.mutexPending.Unlock()
+		return nil, errors.New("cluster node closed")
+	}
+	a.seq++
+	m.Seq = a.seq
+	c := &pendingCall{chanRet: chanRet}
+	if config.ClusterCallTimeout > 0 {
+		// 对端失去响应而连接未断开时, 调用方也能收到错误
+		seq := m.Seq
+		c.timer = time.AfterFunc(config.ClusterCallTimeout, func() {
+			if c := a.remove(seq); c != nil {
+				c.chanRet <- &message{Type: msgResponse, Seq: seq, Err: "cluster call timeout"}
+			}
+		})
+	}
+	a.pending[m.Seq] = c
+	a.mutexPending.Unlock()
+
+	err := a.write(m)
+	if err != nil {
+		a.remove(m.Seq)
+		return nil, err
+	}
+
+	return chanRet, nil
+}
+
+func (a *Agent) onResponse(m *message) {
+	c := a.remove(m.Seq)
+	if c == nil {
+		// 超时的调用也会收到应答
+		log.Log.WithFields(logrus.Fields{"Node": a.node, "Seq": m.Seq}).Debug("unexpected cluster response")
+		return
+	}
+	c.chanRet <- m
+}
+
+// 移除等待中的调用, 已经应答, 超时或连接已关闭时返回 nil
+func (a *Agent) remove(seq uint32) *pendingCall {
+	a.mutexPending.Lock()
+	defer a.mutexPending.Unlock()
 
-func (a *Agent) OnClose() {}
\ No newline at end of file
+	c := a.pending[seq]
+	delete(a.pending, seq)
+	if c != nil && c.timer != nil {
+		c.timer.Stop()
+	}
+	return c
+}
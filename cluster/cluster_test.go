@@ -0,0 +1,107 @@
+package cluster
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jiangzuomin/leaf/chanrpc"
+	"github.com/jiangzuomin/leaf/config"
+)
+
+func TestRPC(t *testing.T) {
+	s := chanrpc.NewServer(10)
+	s.Register("add", func(args []interface{}) interface{} {
+		return args[0].(int) + args[1].(int)
+	})
+	s.Register("swap", func(args []interface{}) []interface{} {
+		return []interface{}{args[1], args[0]}
+	})
+	s.Register("fail", func(args []interface{}) {
+		panic(errors.New("failed"))
+	})
+	s.Register("slow", func(args []interface{}) interface{} {
+		time.Sleep(time.Duration(args[0].(int)) * time.Millisecond)
+		return nil
+	})
+	go func() {
+		for ci := range s.ChanCall {
+			s.Exec(ci)
+		}
+	}()
+	Register("math", s)
+	defer delete(services, "math")
+
+	config.NodeName = "node"
+	config.ListenAddr = "127.0.0.1:0"
+	config.ConnAddrs = nil
+	Init()
+	defer Destroy()
+	// connect to itself on the port chosen by the system
+	connect(ListenAddr().String())
+
+	for i := 0; i < 100; i++ {
+		if _, err := getAgent("node"); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ret, err := Call1("node", "math", "add", 1, 2)
+	if err != nil || ret.(int) != 3 {
+		t.Fatalf("add: %v %v", ret, err)
+	}
+
+	rets, err := CallN("node", "math", "swap", "a", 1)
+	if err != nil || rets[0].(int) != 1 || rets[1].(string) != "a" {
+		t.Fatalf("swap: %v %v", rets, err)
+	}
+
+	if err := Call0("node", "math", "fail"); err == nil {
+		t.Fatal("fail: error expected")
+	}
+
+	if _, err := Call1("node", "math", "swap"); err == nil {
+		t.Fatal("swap: return type mismatch expected")
+	}
+
+	if _, err := Call1("node", "none", "add", 1, 2); err == nil {
+		t.Fatal("service not registered expected")
+	}
+
+	c := chanrpc.NewClient(1)
+	AsynCall(c, "node", "math", "add", 3, 4, func(ret interface{}, err error) {
+		if err != nil || ret.(int) != 7 {
+			t.Fatalf("asyn add: %v %v", ret, err)
+		}
+	})
+	c.Cb(<-c.ChanAsynRet)
+	if !c.Idle() {
+		t.Fatal("client should be idle")
+	}
+
+	defer func(d time.Duration) { config.ClusterCallTimeout = d }(config.ClusterCallTimeout)
+	config.ClusterCallTimeout = 50 * time.Millisecond
+	if _, err := Call1("node", "math", "slow", 200); err == nil || !strings.Contains(err.Error(), "timeout") {
+		t.Fatalf("slow: timeout expected, got %v", err)
+	}
+
+	// the calls waiting for a reply fail when the connection closes
+	config.ClusterCallTimeout = 0
+	chanErr := make(chan error, 1)
+	go func() {
+		_, err := Call1("node", "math", "slow", 200)
+		chanErr <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	Destroy()
+	select {
+	case err := <-chanErr:
+		if err == nil || !strings.Contains(err.Error(), "closed") {
+			t.Fatalf("slow: closed expected, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("call still pending after the connection closed")
+	}
+}
@@ -0,0 +1,227 @@
+package cluster
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+
+	"github.com/jiangzuomin/leaf/chanrpc"
+	"github.com/jiangzuomin/leaf/log"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	msgHello = iota
+	msgRequest
+	msgResponse
+)
+
+// 调用方式, 与 chanrpc 回调函数的返回值类型一致
+const (
+	retGo = -1
+	ret0  = 0
+	ret1  = 1
+	retN  = 2
+)
+
+// ----------------------------------
+// | len | gob encoded cluster message |
+// ----------------------------------
+type message struct {
+	Type    uint8
+	Node    string
+	Seq     uint32
+	Service string
+	ID      interface{}
+	Args    []interface{}
+	N       int
+	Ret     interface{}
+	Err     string
+}
+
+// service name -> server
+var services = make(map[string]*chanrpc.Server)
+
+func init() {
+	// CallN 的返回值
+	gob.Register([]interface{}{})
+}
+
+// you must call the function before calling cluster.Init
+// goroutine not safe
+func Register(service string, server *chanrpc.Server) {
+	if _, ok := services[service]; ok {
+		log.Log.WithField("service", service).Fatal("service is already registered")
+	}
+	services[service] = server
+}
+
+// RegisterType records the concrete type of value so that it can be used as a
+// chanrpc id, argument or return value across nodes. Basic types need no
+// registration.
+func RegisterType(value interface{}) {
+	gob.Register(value)
+}
+
+func marshal(m *message) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(m)
+	return buf.Bytes(), err
+}
+
+func unmarshal(data []byte) (*message, error) {
+	m := new(message)
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(m)
+	return m, err
+}
+
+func (a *Agent) onRequest(m *message) {
+	server := services[m.Service]
+	if server == nil {
+		if m.N != retGo {
+			a.reply(m.Seq, nil, fmt.Errorf("service %v: service not registered", m.Service))
+		}
+		return
+	}
+
+	if m.N == retGo {
+		server.Go(m.ID, m.Args...)
+		return
+	}
+
+	// 同步调用会阻塞, 不能占用读消息的 goroutine
+	go func() {
+		var (
+			ret interface{}
+			err error
+		)
+		switch m.N {
+		case ret0:
+			err = server.Call0(m.ID, m.Args...)
+		case ret1:
+			ret, err = server.Call1(m.ID, m.Args...)
+		case retN:
+			ret, err = server.CallN(m.ID, m.Args...)
+		default:
+			err = fmt.Errorf("invalid return type %v", m.N)
+		}
+		a.reply(m.Seq, ret, err)
+	}()
+}
+
+func (a *Agent) reply(seq uint32, ret interface{}, err error) {
+	m := &message{Type: msgResponse, Seq: seq, Ret: ret}
+	if err != nil {
+		m.Err = err.Error()
+	}
+
+	e := a.write(m)
+	if e != nil {
+		// 返回值无法编码时仍需让调用方收到应答
+		log.Log.WithFields(logrus.Fields{"Node": a.node, "Err": e}).Error("write cluster response")
+		a.write(&message{Type: msgResponse, Seq: seq, Err: e.Error()})
+	}
+}
+
+func getAgent(node string) (*Agent, error) {
+	mutexAgents.RLock()
+	a := agents[node]
+	mutexAgents.RUnlock()
+
+	if a == nil {
+		return nil, fmt.Errorf("cluster node %v not connected", node)
+	}
+	return a, nil
+}
+
+func call(node string, service string, id interface{}, args []interface{}, n int) (chan *message, error) {
+	a, err := getAgent(node)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &message{
+		Type:    msgRequest,
+		Service: service,
+		ID:      id,
+		Args:    args,
+		N:       n,
+	}
+	if n == retGo {
+		return nil, a.write(m)
+	}
+	return a.request(m)
+}
+
+func result(m *message) (interface{}, error) {
+	if m.Err != "" {
+		return m.Ret, errors.New(m.Err)
+	}
+	return m.Ret, nil
+}
+
+// goroutine safe
+func Go(node string, service string, id interface{}, args ...interface{}) error {
+	_, err := call(node, service, id, args, retGo)
+	return err
+}
+
+// goroutine safe
+func Call0(node string, service string, id interface{}, args ...interface{}) error {
+	chanRet, err := call(node, service, id, args, ret0)
+	if err != nil {
+		return err
+	}
+
+	_, err = result(<-chanRet)
+	return err
+}
+
+// goroutine safe
+func Call1(node string, service string, id interface{}, args ...interface{}) (interface{}, error) {
+	chanRet, err := call(node, service, id, args, ret1)
+	if err != nil {
+		return nil, err
+	}
+
+	return result(<-chanRet)
+}
+
+// goroutine safe
+func CallN(node string, service string, id interface{}, args ...interface{}) ([]interface{}, error) {
+	chanRet, err := call(node, service, id, args, retN)
+	if err != nil {
+		return nil, err
+	}
+
+	ret, err := result(<-chanRet)
+	if ret == nil {
+		return nil, err
+	}
+	return ret.([]interface{}), err
+}
+
+// AsynCall calls a function of a service on a remote node, the callback is
+// the last argument and is executed through client.ChanAsynRet.
+// goroutine not safe (same as client)
+func AsynCall(client *chanrpc.Client, node string, service string, id interface{}, _args ...interface{}) {
+	if len(_args) < 1 {
+		log.Log.Fatal("callback function not found")
+	}
+
+	args := _args[:len(_args)-1]
+	cb := _args[len(_args)-1]
+
+	client.AsynCallFunc(func(n int, done func(interface{}, error)) {
+		chanRet, err := call(node, service, id, args, n)
+		if err != nil {
+			done(nil, err)
+			return
+		}
+
+		go func() {
+			done(result(<-chanRet))
+		}()
+	}, cb)
+}
@@ -1,8 +1,16 @@
 package config
 
+import "time"
+
 var (
 	LenStackBuf = 4096
 
+	// shutdown
+	ShutdownTimeout = 10 * time.Second // 每个模块关闭的最长等待时间
+
+	// timer
+	CronStorePath string // Skeleton.Schedule 默认的最近触发时间存储文件, 为空时不持久化, 也不补触发
+
 	// log
 	LogLevel string
 	LogPath string
@@ -13,8 +21,13 @@ var (
 	ConsolePrompt string = "Leaf#"
 	ProfilePath string
 
+	// metrics
+	MetricsAddr string // 不为空时在该地址的 /metrics 导出 Prometheus 格式的指标
+
 	// cluster
+	NodeName string
 	ListenAddr string
 	ConnAddrs []string
 	PendingWriteNum int
+	ClusterCallTimeout = 10 * time.Second // 集群调用等待应答的最长时间, 0 为不限制
 )
\ No newline at end of file
@@ -5,9 +5,13 @@ import (
 	"github.com/jiangzuomin/leaf/chanrpc"
 	"github.com/jiangzuomin/leaf/config"
 	"github.com/jiangzuomin/leaf/log"
+	"github.com/jiangzuomin/leaf/metrics"
+	"github.com/jiangzuomin/leaf/recordfile"
 	"os"
 	"path"
 	"runtime/pprof"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -15,6 +19,8 @@ var commands = []Command{
 	new(CommandHelp),
 	new(CommandCPUProf),
 	new(CommandProf),
+	new(CommandReload),
+	new(CommandMetrics),
 }
 
 type Command interface {
@@ -213,3 +219,106 @@ func (c *CommandProf) run(args []string) string {
 
 	return fn
 }
+
+// reload
+type CommandReload struct{}
+
+func (c *CommandReload) name() string {
+	return "reload"
+}
+
+func (c *CommandReload) help() string {
+	return "reload record files"
+}
+
+func (c *CommandReload) usage() string {
+	return "reload re-reads record files, a file is swapped only if it is valid\r\n\r\n" +
+		"Usage: reload list|all|<file>...\r\n" +
+		"  list   - list record files\r\n" +
+		"  all    - reload all record files\r\n" +
+		"  <file> - reload the given record files, fs:<file> for a file read by ReadFS"
+}
+
+// 通过 ReadFS 读取的文件加上前缀, 与磁盘上的同名文件区分
+const fsPrefix = "fs:"
+
+func (c *CommandReload) run(args []string) string {
+	if len(args) == 0 {
+		return c.usage()
+	}
+
+	names := recordfile.Names()
+	sort.Strings(names)
+	fsNames := recordfile.NamesFS()
+	sort.Strings(fsNames)
+	for _, name := range fsNames {
+		names = append(names, fsPrefix+name)
+	}
+
+	switch args[0] {
+	case "list":
+		output := ""
+		for i, name := range names {
+			if i > 0 {
+				output += "\r\n"
+			}
+			output += name
+		}
+		return output
+	case "all":
+		args = names
+	}
+
+	output := ""
+	for i, name := range args {
+		if i > 0 {
+			output += "\r\n"
+		}
+		var err error
+		if strings.HasPrefix(name, fsPrefix) {
+			err = recordfile.ReloadFS(strings.TrimPrefix(name, fsPrefix))
+		} else {
+			err = recordfile.Reload(name)
+		}
+		if err != nil {
+			output += name + ": " + err.Error()
+		} else {
+			output += name + ": ok"
+		}
+	}
+	return output
+}
+
+// metrics
+type CommandMetrics struct{}
+
+func (c *CommandMetrics) name() string {
+	return "metrics"
+}
+
+func (c *CommandMetrics) help() string {
+	return "show skeleton event loop metrics"
+}
+
+func (c *CommandMetrics) usage() string {
+	return "metrics shows the events handled by each skeleton loop\r\n\r\n" +
+		"Usage: metrics [show|reset]\r\n" +
+		"  show  - show the metrics (default)\r\n" +
+		"  reset - reset the metrics"
+}
+
+func (c *CommandMetrics) run(args []string) string {
+	if len(args) == 0 || args[0] == "show" {
+		var b strings.Builder
+		metrics.Default.WriteText(&b)
+		return b.String()
+	}
+
+	switch args[0] {
+	case "reset":
+		metrics.Default.Reset()
+		return "done"
+	default:
+		return c.usage()
+	}
+}
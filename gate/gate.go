@@ -3,6 +3,7 @@ package gate
 import (
 	"net"
 	"reflect"
+	"sync"
 	"time"
 
 	"github.com/jiangzuomin/leaf/chanrpc"
@@ -28,27 +29,79 @@ type Gate struct {
 	TCPAddr      string
 	LenMsgLen    int
 	LittleEndian bool
+
+	wsServer    *network.WSServer
+	tcpServer   *network.TCPServer
+	agents      map[*agent]struct{} // 已连接的代理
+	mutexAgents sync.Mutex
+}
+
+// 运行中的 gate
+var (
+	gates      = make(map[*Gate]struct{})
+	mutexGates sync.Mutex
+)
+
+// Shutdown stops all running gates from accepting new connections and notifies
+// every connected agent through AgentChanRPC ("ShutdownAgent"), connections are
+// closed later when the gate module is destroyed.
+// goroutine safe
+func Shutdown() {
+	mutexGates.Lock()
+	defer mutexGates.Unlock()
+
+	for gate := range gates {
+		gate.shutdown()
+	}
+}
+
+func (gate *Gate) shutdown() {
+	if gate.wsServer != nil {
+		gate.wsServer.StopAccept()
+	}
+	if gate.tcpServer != nil {
+		gate.tcpServer.StopAccept()
+	}
+
+	if gate.AgentChanRPC == nil {
+		return
+	}
+	gate.mutexAgents.Lock()
+	for a := range gate.agents {
+		gate.AgentChanRPC.Go("ShutdownAgent", a)
+	}
+	gate.mutexAgents.Unlock()
+}
+
+func (gate *Gate) newAgent(conn network.Conn) *agent {
+	a := &agent{conn: conn, gate: gate}
+	gate.mutexAgents.Lock()
+	gate.agents[a] = struct{}{}
+	gate.mutexAgents.Unlock()
+
+	if gate.AgentChanRPC != nil {
+		gate.AgentChanRPC.Go("NewAgent", a)
+	}
+	return a
 }
 
 func (gate *Gate) Run(closeSig chan bool) {
-	// var wsServer *network.WSServer
-	// if gate.WSAddr != "" {
-	// 	wsServer = new(network.WSServer)
-	// 	wsServer.Addr = gate.WSAddr
-	// 	wsServer.MaxConnNum = gate.MaxConnNum
-	// 	wsServer.PendingWriteNum = gate.PendingWriteNum
-	// 	wsServer.MaxMsgLen = gate.MaxMsgLen
-	// 	wsServer.HTTPTimeout = gate.HTTPTimeout
-	// 	wsServer.CertFile = gate.CertFile
-	// 	wsServer.KeyFile = gate.KeyFile
-	// 	wsServer.NewAgent = func(conn *network.WSConn) network.Agent {
-	// 		a := &agent{conn: conn, gate: gate}
-	// 		if gate.AgentChanRPC != nil {
-	// 			gate.AgentChanRPC.Go("NewAgent", a)
-	// 		}
-	// 		return a
-	// 	}
-	// }
+	gate.agents = make(map[*agent]struct{})
+
+	var wsServer *network.WSServer
+	if gate.WSAddr != "" {
+		wsServer = new(network.WSServer)
+		wsServer.Addr = gate.WSAddr
+		wsServer.MaxConnNum = gate.MaxConnNum
+		wsServer.PendingWriteNum = gate.PendingWriteNum
+		wsServer.MaxMsgLen = gate.MaxMsgLen
+		wsServer.HTTPTimeout = gate.HTTPTimeout
+		wsServer.CertFile = gate.CertFile
+		wsServer.KeyFile = gate.KeyFile
+		wsServer.NewAgent = func(conn *network.WSConn) network.Agent {
+			return gate.newAgent(conn)
+		}
+	}
 
 	var tcpServer *network.TCPServer
 	if gate.TCPAddr != "" {
@@ -60,24 +113,32 @@ func (gate *Gate) Run(closeSig chan bool) {
 		tcpServer.MaxMsgLen = gate.MaxMsgLen
 		tcpServer.LittleEndian = gate.LittleEndian
 		tcpServer.NewAgent = func(conn *network.TCPConn) network.Agent {
-			a := &agent{conn: conn, gate: gate}
-			if gate.AgentChanRPC != nil {
-				gate.AgentChanRPC.Go("NewAgent", a)
-			}
-			return a
+			return gate.newAgent(conn)
 		}
 	}
 
-	// if wsServer != nil {
-	// 	wsServer.Start()
-	// }
+	if wsServer != nil {
+		wsServer.Start()
+	}
 	if tcpServer != nil {
 		tcpServer.Start()
 	}
+
+	mutexGates.Lock()
+	gate.wsServer = wsServer
+	gate.tcpServer = tcpServer
+	gates[gate] = struct{}{}
+	mutexGates.Unlock()
+
 	<-closeSig
-	// if wsServer != nil {
-	// 	wsServer.Close()
-	// }
+
+	mutexGates.Lock()
+	delete(gates, gate)
+	mutexGates.Unlock()
+
+	if wsServer != nil {
+		wsServer.Close()
+	}
 	if tcpServer != nil {
 		tcpServer.Close()
 	}
@@ -115,6 +176,10 @@ func (a *agent) Run() {
 }
 
 func (a *agent) OnClose() {
+	a.gate.mutexAgents.Lock()
+	delete(a.gate.agents, a)
+	a.gate.mutexAgents.Unlock()
+
 	if a.gate.AgentChanRPC != nil {
 		err := a.gate.AgentChanRPC.Call0("CloseAgent", a)
 		if err != nil {
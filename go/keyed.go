@@ -0,0 +1,121 @@
+package g
+
+import (
+	"container/list"
+	"runtime"
+	"sync"
+
+	"github.com/jiangzuomin/leaf/config"
+	"github.com/jiangzuomin/leaf/log"
+	"github.com/sirupsen/logrus"
+)
+
+// KeyedContext serializes the calls of the same key like a LinearContext per
+// key, different keys run concurrently on the workers of a Pool; a key is
+// dropped once it has nothing queued
+type KeyedContext struct {
+	p     *Pool
+	own   bool // p 由 Go.NewKeyedContext 创建, Close 时关闭
+	mutex sync.Mutex
+	keys  map[interface{}]*list.List
+}
+
+// NewKeyedContext runs the calls on a new pool of limit workers, so at most
+// limit keys run at once and as many wait in the queue of the pool,
+// limit <= 0 means runtime.NumCPU()
+func (g *Go) NewKeyedContext(limit int) *KeyedContext {
+	if limit <= 0 {
+		limit = runtime.NumCPU()
+	}
+
+	c := g.NewPool(limit, limit).NewKeyedContext()
+	c.own = true
+	return c
+}
+
+// NewKeyedContext runs the calls on the workers of p, a key with queued calls
+// takes one worker (or one place in the queue of p) until it has none left
+func (p *Pool) NewKeyedContext() *KeyedContext {
+	c := new(KeyedContext)
+	c.p = p
+	c.keys = make(map[interface{}]*list.List)
+	return c
+}
+
+// Go queues f after the calls of the same key, it waits while the queue of the
+// pool is full (backpressure), as Pool.Go does
+// goroutine not safe, call it on the goroutine of its Go
+func (c *KeyedContext) Go(key interface{}, f func(), cb func()) {
+	c.p.g.pendingGo++
+
+	c.mutex.Lock()
+	linearGo, ok := c.keys[key]
+	if !ok {
+		linearGo = list.New()
+		c.keys[key] = linearGo
+	}
+	linearGo.PushBack(&LinearGo{f: f, cb: cb})
+	c.mutex.Unlock()
+
+	// 队列中已有调用时由正在执行的 worker 继续执行
+	if !ok {
+		c.p.tasks <- func() { c.run(key, linearGo) }
+	}
+}
+
+func (c *KeyedContext) run(key interface{}, linearGo *list.List) {
+	for {
+		c.mutex.Lock()
+		e := linearGo.Front().Value.(*LinearGo)
+		c.mutex.Unlock()
+
+		c.exec(e)
+
+		// 先移除再投递 cb, cb 中 Len 不会计入已空闲的 key
+		c.mutex.Lock()
+		linearGo.Remove(linearGo.Front())
+		idle := linearGo.Len() == 0
+		if idle {
+			delete(c.keys, key)
+		}
+		c.mutex.Unlock()
+
+		c.p.cbs.Post(e.cb)
+		if idle {
+			return
+		}
+	}
+}
+
+func (c *KeyedContext) exec(e *LinearGo) {
+	defer func() {
+		if r := recover(); r != nil {
+			if config.LenStackBuf > 0 {
+				buf := make([]byte, config.LenStackBuf)
+				l := runtime.Stack(buf, false)
+				log.Log.WithFields(logrus.Fields{"recover": r, "buf": buf[:l]}).Error()
+			} else {
+				log.Log.WithField("recover", r).Error()
+			}
+		}
+	}()
+
+	e.f()
+}
+
+// Len returns the number of keys with queued or running calls
+// goroutine safe
+func (c *KeyedContext) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.keys)
+}
+
+// Close stops the pool created by Go.NewKeyedContext after the queued calls,
+// the callbacks are left to Go.Close. A context of Pool.NewKeyedContext is
+// closed with its pool.
+func (c *KeyedContext) Close() {
+	if c.own {
+		c.p.Close()
+	}
+}
@@ -0,0 +1,83 @@
+package g
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestKeyedContext(t *testing.T) {
+	d := New(10)
+	c := d.NewKeyedContext(2)
+
+	var mutex sync.Mutex
+	order := make(map[int][]int)
+	var running, maxRunning int32
+
+	for i := 0; i < 100; i++ {
+		key, n := i%5, i
+		c.Go(key, func() {
+			r := atomic.AddInt32(&running, 1)
+			for {
+				m := atomic.LoadInt32(&maxRunning)
+				if r <= m || atomic.CompareAndSwapInt32(&maxRunning, m, r) {
+					break
+				}
+			}
+			mutex.Lock()
+			order[key] = append(order[key], n)
+			mutex.Unlock()
+			atomic.AddInt32(&running, -1)
+		}, nil)
+	}
+	d.Close()
+	c.Close()
+
+	for key, ns := range order {
+		if len(ns) != 20 {
+			t.Fatalf("key %v: %v calls", key, len(ns))
+		}
+		for i := 1; i < len(ns); i++ {
+			if ns[i] < ns[i-1] {
+				t.Fatalf("key %v: out of order %v", key, ns)
+			}
+		}
+	}
+	if maxRunning > 2 {
+		t.Fatalf("%v calls running at once", maxRunning)
+	}
+	if c.Len() != 0 {
+		t.Fatalf("%v idle keys left", c.Len())
+	}
+}
+
+func TestKeyedContextWorkers(t *testing.T) {
+	d := New(10)
+	c := d.NewKeyedContext(4)
+
+	base := runtime.NumGoroutine()
+	block := make(chan struct{})
+	done := make(chan bool)
+	go func() {
+		// 每个 key 都不同, 也只使用 4 个 worker
+		for i := 0; i < 1000; i++ {
+			c.Go(i, func() { <-block }, nil)
+		}
+		done <- true
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	if n := runtime.NumGoroutine() - base; n > 5 {
+		t.Fatalf("%v goroutines started", n)
+	}
+
+	close(block)
+	<-done
+	d.Close()
+	c.Close()
+	if c.Len() != 0 {
+		t.Fatalf("%v idle keys left", c.Len())
+	}
+}
@@ -0,0 +1,104 @@
+package g
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+
+	"github.com/jiangzuomin/leaf/config"
+	"github.com/jiangzuomin/leaf/internal/relay"
+	"github.com/jiangzuomin/leaf/log"
+	"github.com/sirupsen/logrus"
+)
+
+var ErrPoolFull = errors.New("go pool queue is full")
+
+// Pool runs f on a fixed number of worker goroutines instead of one goroutine
+// per call, cb still goes through ChanCb of its Go
+// goroutine not safe, call Go/TryGo on the goroutine of its Go
+type Pool struct {
+	g         *Go
+	tasks     chan func()
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+	cbs       *relay.Relay[func()] // worker 不阻塞在 ChanCb 上, 否则队列满时 Go 会死锁
+}
+
+// NewPool starts workers goroutines sharing a queue of queueLen calls
+func (g *Go) NewPool(workers int, queueLen int) *Pool {
+	if workers <= 0 {
+		workers = 1
+	}
+	if queueLen < 0 {
+		queueLen = 0
+	}
+
+	p := new(Pool)
+	p.g = g
+	p.tasks = make(chan func(), queueLen)
+	p.cbs = relay.New(g.ChanCb)
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.worker()
+	}
+	return p
+}
+
+func (p *Pool) worker() {
+	defer p.wg.Done()
+	for task := range p.tasks {
+		task()
+	}
+}
+
+func (p *Pool) exec(f func(), cb func()) {
+	defer func() {
+		p.cbs.Post(cb)
+		if r := recover(); r != nil {
+			if config.LenStackBuf > 0 {
+				buf := make([]byte, config.LenStackBuf)
+				l := runtime.Stack(buf, false)
+				log.Log.WithFields(logrus.Fields{"recover": r, "buf": buf[:l]}).Error()
+			} else {
+				log.Log.WithField("recover", r).Error()
+			}
+		}
+	}()
+
+	f()
+}
+
+// Go queues f, waiting while the queue is full (backpressure).
+// No callback runs while it waits. The workers do not wait for ChanCb either,
+// the callbacks that do not fit in ChanCb are kept without limit until the
+// goroutine of Go receives them, so the queue bounds the pending calls but not
+// the finished ones.
+func (p *Pool) Go(f func(), cb func()) {
+	p.g.pendingGo++
+	p.tasks <- func() { p.exec(f, cb) }
+}
+
+// TryGo queues f or returns ErrPoolFull without calling cb if the queue is full
+func (p *Pool) TryGo(f func(), cb func()) error {
+	select {
+	case p.tasks <- func() { p.exec(f, cb) }:
+		p.g.pendingGo++
+		return nil
+	default:
+		return ErrPoolFull
+	}
+}
+
+// Len returns the number of queued calls not yet taken by a worker
+func (p *Pool) Len() int {
+	return len(p.tasks)
+}
+
+// Close stops the workers after the queued calls and waits for them, the
+// callbacks are left to Go.Close
+func (p *Pool) Close() {
+	p.closeOnce.Do(func() {
+		close(p.tasks)
+	})
+	p.wg.Wait()
+}
@@ -0,0 +1,83 @@
+package g
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPool(t *testing.T) {
+	d := New(1)
+	p := d.NewPool(2, 1)
+
+	var running, maxRunning int32
+	block := make(chan struct{})
+	f := func() {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		<-block
+		atomic.AddInt32(&running, -1)
+	}
+
+	var done int
+	cb := func() { done++ }
+
+	// 2 workers + 1 queued at most
+	accepted := 0
+	for p.TryGo(f, cb) == nil {
+		accepted++
+	}
+	if accepted == 0 || accepted > 3 {
+		t.Fatalf("accepted %v calls", accepted)
+	}
+	close(block)
+
+	// backpressure, no callback runs while Go waits although ChanCb (len 1) is full
+	for i := 0; i < 20; i++ {
+		p.Go(f, cb)
+	}
+	if done != 0 {
+		t.Fatalf("%v callbacks ran inside Go", done)
+	}
+	d.Close()
+	p.Close()
+
+	if !d.Idle() || done != accepted+20 {
+		t.Fatalf("idle %v, done %v", d.Idle(), done)
+	}
+	if maxRunning > 2 {
+		t.Fatalf("%v calls running at once", maxRunning)
+	}
+}
+
+func TestPoolChanCbFull(t *testing.T) {
+	d := New(1)
+	p := d.NewPool(1, 1)
+
+	var done int
+	finished := make(chan bool)
+	go func() {
+		// ChanCb 只能放 1 个 cb, worker 若等待 ChanCb, Go 会一直等待队列
+		for i := 0; i < 10; i++ {
+			p.Go(func() {}, func() { done++ })
+		}
+		finished <- true
+	}()
+
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		t.Fatal("Go blocked while ChanCb is full")
+	}
+	d.Close()
+	p.Close()
+
+	if done != 10 {
+		t.Fatalf("done %v", done)
+	}
+}
@@ -0,0 +1,71 @@
+// Package relay sends values to a channel without blocking the sender
+package relay
+
+import (
+	"sync"
+)
+
+// Relay sends values to a channel in order, the values that do not fit are
+// kept and sent by a goroutine started on demand, so Post never blocks.
+// The number of kept values is not bounded.
+// goroutine safe
+type Relay[T any] struct {
+	ch         chan T
+	mutex      sync.Mutex
+	pending    []T
+	forwarding bool
+}
+
+func New[T any](ch chan T) *Relay[T] {
+	r := new(Relay[T])
+	r.ch = ch
+	return r
+}
+
+// Post sends v to the channel or keeps it if the channel is full
+func (r *Relay[T]) Post(v T) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// 已有等待的值时必须排在其后, 保证顺序
+	if !r.forwarding {
+		select {
+		case r.ch <- v:
+			return
+		default:
+		}
+		r.forwarding = true
+		go r.forward()
+	}
+	r.pending = append(r.pending, v)
+}
+
+// Len returns the number of values kept, not yet sent to the channel,
+// read it before the length of the channel to count every value
+func (r *Relay[T]) Len() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.pending)
+}
+
+func (r *Relay[T]) forward() {
+	var zero T
+	for {
+		r.mutex.Lock()
+		if len(r.pending) == 0 {
+			r.forwarding = false
+			r.mutex.Unlock()
+			return
+		}
+		v := r.pending[0]
+		r.mutex.Unlock()
+
+		// 发送完成后才移除, Len 和通道长度之和不会漏掉正在发送的值
+		r.ch <- v
+
+		r.mutex.Lock()
+		r.pending[0] = zero
+		r.pending = r.pending[1:]
+		r.mutex.Unlock()
+	}
+}
@@ -0,0 +1,29 @@
+package relay
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRelay(t *testing.T) {
+	ch := make(chan int, 1)
+	r := New(ch)
+
+	// 通道已满, Post 也不阻塞
+	for i := 0; i < 100; i++ {
+		r.Post(i)
+	}
+
+	for i := 0; i < 100; i++ {
+		if v := <-ch; v != i {
+			t.Fatalf("expected %v, got %v", i, v)
+		}
+	}
+	// 最后一个值在发送完成后才移除
+	for i := 0; r.Len() != 0; i++ {
+		if i == 100 {
+			t.Fatalf("%v values left", r.Len())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -1,17 +1,29 @@
 package leaf
 
 import (
+	"os"
+	"os/signal"
+	"syscall"
+
 	"github.com/jiangzuomin/leaf/cluster"
 	"github.com/jiangzuomin/leaf/console"
+	"github.com/jiangzuomin/leaf/gate"
 	"github.com/jiangzuomin/leaf/log"
+	"github.com/jiangzuomin/leaf/metrics"
 	"github.com/jiangzuomin/leaf/module"
-	"os"
-	"os/signal"
 )
 
+var shutdownSig = make(chan bool, 1)
+
 func Run(mods ...module.Module) {
 	log.Log.Info("Leaf starting up")
 
+	// 丢弃上次 Run 之前残留的 Shutdown 请求
+	select {
+	case <-shutdownSig:
+	default:
+	}
+
 	// module
 	for i := 0; i < len(mods); i++ {
 		module.Register(mods[i])
@@ -24,12 +36,34 @@ func Run(mods ...module.Module) {
 	// console
 	console.Init()
 
+	// metrics
+	metrics.Init()
+
 	// close
+	// os.Kill 无法被捕获, 容器编排发送的是 SIGTERM
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, os.Kill)
-	sig := <-c
-	log.Log.WithField("signal", sig).Error("Leaf closing down")
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(c)
+	select {
+	case sig := <-c:
+		log.Log.WithField("signal", sig).Error("Leaf closing down")
+	case <-shutdownSig:
+		log.Log.Error("Leaf closing down")
+	}
+
+	// 先停止接受新连接并通知已连接的代理, 再依次关闭各模块
+	gate.Shutdown()
 	console.Destroy()
+	metrics.Destroy()
 	cluster.Destroy()
 	module.Destroy()
 }
+
+// Shutdown makes a running Run close down as if it received SIGTERM.
+// goroutine safe
+func Shutdown() {
+	select {
+	case shutdownSig <- true:
+	default:
+	}
+}
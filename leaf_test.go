@@ -0,0 +1,89 @@
+package leaf
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jiangzuomin/leaf/config"
+)
+
+type stuckModule struct {
+	destroyed bool
+}
+
+func (m *stuckModule) OnInit()    {}
+func (m *stuckModule) OnDestroy() { m.destroyed = true }
+func (m *stuckModule) Run(closeSig chan bool) {
+	select {}
+}
+
+type normalModule struct {
+	destroyed bool
+}
+
+func (m *normalModule) OnInit()    {}
+func (m *normalModule) OnDestroy() { m.destroyed = true }
+func (m *normalModule) Run(closeSig chan bool) {
+	<-closeSig
+}
+
+func TestShutdown(t *testing.T) {
+	config.ShutdownTimeout = 50 * time.Millisecond
+
+	stuck := new(stuckModule)
+	normal := new(normalModule)
+
+	done := make(chan bool)
+	go func() {
+		Run(normal, stuck)
+		close(done)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	Shutdown()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after Shutdown")
+	}
+
+	if stuck.destroyed {
+		t.Fatal("stuck module should not be destroyed")
+	}
+	if !normal.destroyed {
+		t.Fatal("normal module should be destroyed")
+	}
+}
+
+func TestShutdownBeforeRun(t *testing.T) {
+	config.ShutdownTimeout = 50 * time.Millisecond
+
+	// Run 之前的 Shutdown 不应让 Run 立即返回
+	Shutdown()
+
+	m := new(normalModule)
+	done := make(chan bool)
+	go func() {
+		Run(m)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("Run returned on a Shutdown issued before it started")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	Shutdown()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after Shutdown")
+	}
+
+	if !m.destroyed {
+		t.Fatal("module should be destroyed")
+	}
+}
@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/jiangzuomin/leaf/config"
+	"github.com/jiangzuomin/leaf/log"
+)
+
+var server *http.Server
+
+// Init serves Default in the Prometheus text format on
+// config.MetricsAddr/metrics, it does nothing if the address is empty
+func Init() {
+	if config.MetricsAddr == "" {
+		return
+	}
+
+	ln, err := net.Listen("tcp", config.MetricsAddr)
+	if err != nil {
+		log.Log.WithField("err", err).Fatal("metrics listen error")
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Default)
+	server = &http.Server{Handler: mux}
+
+	go func() {
+		err := server.Serve(ln)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Log.WithField("err", err).Error("metrics serve error")
+		}
+	}()
+}
+
+func Destroy() {
+	if server != nil {
+		server.Close()
+		server = nil
+	}
+}
@@ -0,0 +1,209 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jiangzuomin/leaf/log"
+	"github.com/sirupsen/logrus"
+)
+
+// Recorder receives the events handled by the skeleton loops
+// goroutine safe
+type Recorder interface {
+	// Observe records one event of source handled in d, id is the chanrpc id
+	// for calls and nil otherwise
+	Observe(module string, source string, id interface{}, d time.Duration)
+	// QueueDepth records the number of events of source waiting to be handled
+	QueueDepth(module string, source string, n int)
+}
+
+// Buckets are the upper bounds of the latency histograms
+var Buckets = []time.Duration{
+	100 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+}
+
+// Stats of one event source of one module
+type Stats struct {
+	Module   string
+	Source   string
+	Count    uint64
+	Sum      time.Duration
+	Max      time.Duration
+	Buckets  []uint64 // 对应 Buckets, 最后一个为 +Inf, 非累计
+	Slow     uint64
+	SlowID   interface{} // 最近一次慢处理的 chanrpc id
+	Depth    int
+	MaxDepth int
+}
+
+type key struct {
+	module string
+	source string
+}
+
+// Registry is the default Recorder keeping the stats in memory
+type Registry struct {
+	// SlowThreshold marks the events handled slower as slow and logs them,
+	// 0 disables it
+	SlowThreshold time.Duration
+
+	mutex sync.Mutex
+	stats map[key]*Stats
+}
+
+var Default = NewRegistry()
+
+func NewRegistry() *Registry {
+	r := new(Registry)
+	r.SlowThreshold = 100 * time.Millisecond
+	r.stats = make(map[key]*Stats)
+	return r
+}
+
+func (r *Registry) get(module string, source string) *Stats {
+	k := key{module, source}
+	s, ok := r.stats[k]
+	if !ok {
+		s = &Stats{Module: module, Source: source, Buckets: make([]uint64, len(Buckets)+1)}
+		r.stats[k] = s
+	}
+	return s
+}
+
+func (r *Registry) Observe(module string, source string, id interface{}, d time.Duration) {
+	r.mutex.Lock()
+	s := r.get(module, source)
+	s.Count++
+	s.Sum += d
+	if d > s.Max {
+		s.Max = d
+	}
+	i := sort.Search(len(Buckets), func(i int) bool { return d <= Buckets[i] })
+	s.Buckets[i]++
+	slow := r.SlowThreshold > 0 && d >= r.SlowThreshold
+	if slow {
+		s.Slow++
+		s.SlowID = id
+	}
+	r.mutex.Unlock()
+
+	if slow {
+		log.Log.WithFields(logrus.Fields{"module": module, "source": source, "id": id, "duration": d}).Warn("slow handler")
+	}
+}
+
+func (r *Registry) QueueDepth(module string, source string, n int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	s := r.get(module, source)
+	s.Depth = n
+	if n > s.MaxDepth {
+		s.MaxDepth = n
+	}
+}
+
+// Snapshot returns a copy of the stats sorted by module and source
+func (r *Registry) Snapshot() []Stats {
+	r.mutex.Lock()
+	stats := make([]Stats, 0, len(r.stats))
+	for _, s := range r.stats {
+		c := *s
+		c.Buckets = append([]uint64(nil), s.Buckets...)
+		stats = append(stats, c)
+	}
+	r.mutex.Unlock()
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Module != stats[j].Module {
+			return stats[i].Module < stats[j].Module
+		}
+		return stats[i].Source < stats[j].Source
+	})
+	return stats
+}
+
+// Reset drops all stats
+func (r *Registry) Reset() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.stats = make(map[key]*Stats)
+}
+
+// WriteText writes a human readable table, lines end with \r\n for the console
+func (r *Registry) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "%-12v %-8v %10v %10v %10v %8v %6v %8v  %v",
+		"Module", "Source", "Count", "Avg", "Max", "Slow", "Depth", "MaxDepth", "SlowID")
+	for _, s := range r.Snapshot() {
+		var avg time.Duration
+		if s.Count > 0 {
+			avg = s.Sum / time.Duration(s.Count)
+		}
+		slowID := ""
+		if s.SlowID != nil {
+			slowID = fmt.Sprint(s.SlowID)
+		}
+		fmt.Fprintf(w, "\r\n%-12v %-8v %10v %10v %10v %8v %6v %8v  %v",
+			s.Module, s.Source, s.Count, avg, s.Max, s.Slow, s.Depth, s.MaxDepth, slowID)
+	}
+}
+
+// WritePrometheus writes the stats in the Prometheus text format
+func (r *Registry) WritePrometheus(w io.Writer) {
+	stats := r.Snapshot()
+
+	fmt.Fprintln(w, "# HELP leaf_skeleton_events_total Events handled by the skeleton loop.")
+	fmt.Fprintln(w, "# TYPE leaf_skeleton_events_total counter")
+	for _, s := range stats {
+		fmt.Fprintf(w, "leaf_skeleton_events_total{%v} %v\n", labels(s), s.Count)
+	}
+
+	fmt.Fprintln(w, "# HELP leaf_skeleton_event_seconds Time to handle an event.")
+	fmt.Fprintln(w, "# TYPE leaf_skeleton_event_seconds histogram")
+	for _, s := range stats {
+		var cumulative uint64
+		for i, b := range Buckets {
+			cumulative += s.Buckets[i]
+			fmt.Fprintf(w, "leaf_skeleton_event_seconds_bucket{%v,le=\"%v\"} %v\n", labels(s), b.Seconds(), cumulative)
+		}
+		fmt.Fprintf(w, "leaf_skeleton_event_seconds_bucket{%v,le=\"+Inf\"} %v\n", labels(s), s.Count)
+		fmt.Fprintf(w, "leaf_skeleton_event_seconds_sum{%v} %v\n", labels(s), s.Sum.Seconds())
+		fmt.Fprintf(w, "leaf_skeleton_event_seconds_count{%v} %v\n", labels(s), s.Count)
+	}
+
+	fmt.Fprintln(w, "# HELP leaf_skeleton_slow_events_total Events handled slower than the slow threshold.")
+	fmt.Fprintln(w, "# TYPE leaf_skeleton_slow_events_total counter")
+	for _, s := range stats {
+		fmt.Fprintf(w, "leaf_skeleton_slow_events_total{%v} %v\n", labels(s), s.Slow)
+	}
+
+	fmt.Fprintln(w, "# HELP leaf_skeleton_queue_depth Events waiting to be handled.")
+	fmt.Fprintln(w, "# TYPE leaf_skeleton_queue_depth gauge")
+	for _, s := range stats {
+		fmt.Fprintf(w, "leaf_skeleton_queue_depth{%v} %v\n", labels(s), s.Depth)
+	}
+}
+
+func labels(s Stats) string {
+	return fmt.Sprintf("module=%q,source=%q", s.Module, s.Source)
+}
+
+// ServeHTTP exports the stats in the Prometheus text format
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.WritePrometheus(w)
+}
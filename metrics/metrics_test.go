@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	r.SlowThreshold = 50 * time.Millisecond
+
+	r.Observe("game", "call", "Login", time.Millisecond)
+	r.Observe("game", "call", "Save", time.Second)
+	r.QueueDepth("game", "call", 3)
+	r.QueueDepth("game", "call", 1)
+	r.Observe("game", "timer", nil, 200*time.Microsecond)
+
+	stats := r.Snapshot()
+	if len(stats) != 2 {
+		t.Fatalf("unexpected stats %v", stats)
+	}
+	call := stats[0]
+	if call.Count != 2 || call.Slow != 1 || call.SlowID != "Save" || call.Depth != 1 || call.MaxDepth != 3 {
+		t.Fatalf("unexpected call stats %+v", call)
+	}
+
+	var b strings.Builder
+	r.WritePrometheus(&b)
+	for _, line := range []string{
+		`leaf_skeleton_events_total{module="game",source="call"} 2`,
+		`leaf_skeleton_event_seconds_bucket{module="game",source="call",le="0.001"} 1`,
+		`leaf_skeleton_event_seconds_bucket{module="game",source="call",le="+Inf"} 2`,
+		`leaf_skeleton_slow_events_total{module="game",source="call"} 1`,
+		`leaf_skeleton_queue_depth{module="game",source="call"} 1`,
+	} {
+		if !strings.Contains(b.String(), line+"\n") {
+			t.Errorf("missing %v in\n%v", line, b.String())
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package module
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 拓扑排序, 依赖的模块排在前面, 无依赖关系的模块保持注册顺序
+func sortModules(mods []*module) ([]*module, error) {
+	byName := make(map[string]*module)
+	for _, m := range mods {
+		if _, ok := byName[m.name]; ok {
+			// 未命名的同类型模块允许重复注册, 依赖该类型名时指向第一个
+			if _, named := m.mi.(Named); named {
+				return nil, fmt.Errorf("module %v: duplicate name", m.name)
+			}
+			continue
+		}
+		byName[m.name] = m
+	}
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[*module]int)
+	sorted := make([]*module, 0, len(mods))
+	var path []string
+
+	var visit func(m *module) error
+	visit = func(m *module) error {
+		switch state[m] {
+		case visited:
+			return nil
+		case visiting:
+			// path 中从 m 开始的部分即为环
+			for i, name := range path {
+				if name == m.name {
+					return fmt.Errorf("dependency cycle: %v -> %v",
+						strings.Join(path[i:], " -> "), m.name)
+				}
+			}
+			panic("bug")
+		}
+
+		state[m] = visiting
+		path = append(path, m.name)
+
+		if d, ok := m.mi.(Depender); ok {
+			for _, dep := range d.Dependencies() {
+				dm, ok := byName[dep]
+				if !ok {
+					return fmt.Errorf("module %v: dependency %v not registered", m.name, dep)
+				}
+				if err := visit(dm); err != nil {
+					return err
+				}
+			}
+		}
+
+		path = path[:len(path)-1]
+		state[m] = visited
+		sorted = append(sorted, m)
+		return nil
+	}
+
+	for _, m := range mods {
+		if err := visit(m); err != nil {
+			return nil, err
+		}
+	}
+
+	return sorted, nil
+}
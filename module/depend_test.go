@@ -0,0 +1,91 @@
+package module
+
+import (
+	"testing"
+)
+
+type testModule struct {
+	name string
+	deps []string
+}
+
+func (m *testModule) OnInit()                {}
+func (m *testModule) OnDestroy()             {}
+func (m *testModule) Run(closeSig chan bool) {}
+func (m *testModule) Name() string           { return m.name }
+func (m *testModule) Dependencies() []string { return m.deps }
+
+func newTestModules(mis ...*testModule) []*module {
+	var ms []*module
+	for _, mi := range mis {
+		ms = append(ms, &module{mi: mi, name: moduleName(mi)})
+	}
+	return ms
+}
+
+func TestSortModules(t *testing.T) {
+	ms := newTestModules(
+		&testModule{name: "gate", deps: []string{"login", "game"}},
+		&testModule{name: "login", deps: []string{"db"}},
+		&testModule{name: "game", deps: []string{"db"}},
+		&testModule{name: "db"},
+	)
+
+	sorted, err := sortModules(ms)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, m := range sorted {
+		names = append(names, m.name)
+	}
+	want := []string{"db", "login", "game", "gate"}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, names)
+		}
+	}
+}
+
+func TestSortModulesError(t *testing.T) {
+	cycle := newTestModules(
+		&testModule{name: "a", deps: []string{"b"}},
+		&testModule{name: "b", deps: []string{"c"}},
+		&testModule{name: "c", deps: []string{"a"}},
+	)
+	_, err := sortModules(cycle)
+	if err == nil || err.Error() != "dependency cycle: a -> b -> c -> a" {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	missing := newTestModules(&testModule{name: "a", deps: []string{"b"}})
+	if _, err := sortModules(missing); err == nil {
+		t.Fatal("missing dependency expected")
+	}
+
+	duplicate := newTestModules(&testModule{name: "a"}, &testModule{name: "a"})
+	if _, err := sortModules(duplicate); err == nil {
+		t.Fatal("duplicate name expected")
+	}
+}
+
+type panicStopModule struct {
+	destroyed bool
+}
+
+func (m *panicStopModule) OnInit()                {}
+func (m *panicStopModule) OnDestroy()             { m.destroyed = true }
+func (m *panicStopModule) Run(closeSig chan bool) { <-closeSig }
+func (m *panicStopModule) OnStopping()            { panic("stopping") }
+
+func TestStoppingPanic(t *testing.T) {
+	m := new(panicStopModule)
+	Register(m)
+	Init()
+	Destroy()
+
+	if !m.destroyed {
+		t.Fatal("module should be destroyed even if OnStopping panics")
+	}
+}
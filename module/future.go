@@ -0,0 +1,215 @@
+package module
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+
+	"github.com/jiangzuomin/leaf/chanrpc"
+	"github.com/jiangzuomin/leaf/config"
+)
+
+// Future is the result of an asynchronous step (Skeleton.GoFuture,
+// Skeleton.AsynCallFuture, Skeleton.AfterFuture ...). It is resolved by a
+// skeleton callback, so its handlers run on the skeleton goroutine.
+// goroutine not safe, use it on the skeleton goroutine
+type Future struct {
+	done     bool
+	adopted  bool
+	ret      interface{}
+	err      error
+	handlers []func(interface{}, error)
+}
+
+// PanicError is the error of a future whose step panicked
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+func NewFuture() *Future {
+	return new(Future)
+}
+
+// Resolved returns a future already resolved with ret and err
+func Resolved(ret interface{}, err error) *Future {
+	f := NewFuture()
+	f.Resolve(ret, err)
+	return f
+}
+
+// Resolve completes f, later calls are ignored. If ret is a *Future, f is
+// resolved with its result instead.
+func (f *Future) Resolve(ret interface{}, err error) {
+	if f.done || f.adopted {
+		return
+	}
+
+	if next, ok := ret.(*Future); ok && err == nil {
+		f.adopted = true
+		next.Done(f.resolve)
+		return
+	}
+	f.resolve(ret, err)
+}
+
+func (f *Future) resolve(ret interface{}, err error) {
+	f.done = true
+	f.ret = ret
+	f.err = err
+
+	handlers := f.handlers
+	f.handlers = nil
+	for _, h := range handlers {
+		h(ret, err)
+	}
+}
+
+func (f *Future) IsDone() bool {
+	return f.done
+}
+
+// Result returns the result of a resolved future
+func (f *Future) Result() (interface{}, error) {
+	return f.ret, f.err
+}
+
+// Done calls cb with the result once f is resolved (at once if it already is)
+func (f *Future) Done(cb func(ret interface{}, err error)) {
+	if f.done {
+		cb(f.ret, f.err)
+		return
+	}
+	f.handlers = append(f.handlers, cb)
+}
+
+// Then calls fn with the result of f if it succeeded, the returned future is
+// resolved with the result of fn (fn may return a *Future to chain another
+// asynchronous step). An error of f or a panic in fn skips to the returned
+// future.
+func (f *Future) Then(fn func(ret interface{}) (interface{}, error)) *Future {
+	next := NewFuture()
+	f.Done(func(ret interface{}, err error) {
+		if err != nil {
+			next.Resolve(nil, err)
+			return
+		}
+		next.Resolve(protect(func() (interface{}, error) { return fn(ret) }))
+	})
+	return next
+}
+
+// Catch calls fn with the error of f if it failed, the returned future is
+// resolved with the result of fn, or with the result of f if it succeeded
+func (f *Future) Catch(fn func(err error) (interface{}, error)) *Future {
+	next := NewFuture()
+	f.Done(func(ret interface{}, err error) {
+		if err == nil {
+			next.Resolve(ret, nil)
+			return
+		}
+		next.Resolve(protect(func() (interface{}, error) { return fn(err) }))
+	})
+	return next
+}
+
+// All resolves with the results ([]interface{}) of fs in order once all of
+// them succeeded, or with the first error
+func All(fs ...*Future) *Future {
+	all := NewFuture()
+	rets := make([]interface{}, len(fs))
+	pending := len(fs)
+	if pending == 0 {
+		all.Resolve(rets, nil)
+		return all
+	}
+
+	for i, f := range fs {
+		i := i
+		f.Done(func(ret interface{}, err error) {
+			if err != nil {
+				all.Resolve(nil, err)
+				return
+			}
+			rets[i] = ret
+			pending--
+			if pending == 0 {
+				all.Resolve(rets, nil)
+			}
+		})
+	}
+	return all
+}
+
+// Any resolves with the first successful result of fs, or with the last
+// error if all of them failed
+func Any(fs ...*Future) *Future {
+	first := NewFuture()
+	pending := len(fs)
+	if pending == 0 {
+		first.Resolve(nil, fmt.Errorf("no future"))
+		return first
+	}
+
+	for _, f := range fs {
+		f.Done(func(ret interface{}, err error) {
+			pending--
+			if err == nil {
+				first.Resolve(ret, nil)
+			} else if pending == 0 {
+				first.Resolve(nil, err)
+			}
+		})
+	}
+	return first
+}
+
+func protect(fn func() (interface{}, error)) (ret interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			var buf []byte
+			if config.LenStackBuf > 0 {
+				buf = make([]byte, config.LenStackBuf)
+				buf = buf[:runtime.Stack(buf, false)]
+			}
+			ret, err = nil, &PanicError{Value: r, Stack: buf}
+		}
+	}()
+
+	return fn()
+}
+
+// GoFuture executes f on another goroutine like Go
+func (s *Skeleton) GoFuture(f func() (interface{}, error)) *Future {
+	fut := NewFuture()
+	var ret interface{}
+	var err error
+	s.Go(func() {
+		ret, err = protect(f)
+	}, func() {
+		fut.Resolve(ret, err)
+	})
+	return fut
+}
+
+// AsynCallFuture calls id of server like AsynCall with a single return value
+func (s *Skeleton) AsynCallFuture(server *chanrpc.Server, id interface{}, args ...interface{}) *Future {
+	fut := NewFuture()
+	s.AsynCall(server, id, append(args, func(ret interface{}, err error) {
+		fut.Resolve(ret, err)
+	})...)
+	return fut
+}
+
+// AfterFuture resolves with nil after d
+func (s *Skeleton) AfterFuture(d time.Duration) *Future {
+	fut := NewFuture()
+	s.AfterFunc(d, func() {
+		fut.Resolve(nil, nil)
+	})
+	return fut
+}
@@ -0,0 +1,87 @@
+package module
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jiangzuomin/leaf/chanrpc"
+)
+
+func TestFuture(t *testing.T) {
+	a, b := NewFuture(), NewFuture()
+
+	var rets []interface{}
+	All(a, b).Then(func(ret interface{}) (interface{}, error) {
+		rets = ret.([]interface{})
+		return nil, nil
+	})
+	b.Resolve(2, nil)
+	a.Resolve(1, nil)
+	if len(rets) != 2 || rets[0] != 1 || rets[1] != 2 {
+		t.Fatalf("all: %v", rets)
+	}
+
+	errFailed := errors.New("failed")
+	ret, err := Any(Resolved(nil, errFailed), Resolved(3, nil)).Result()
+	if ret != 3 || err != nil {
+		t.Fatalf("any: %v %v", ret, err)
+	}
+
+	var final error
+	Resolved(1, nil).Then(func(ret interface{}) (interface{}, error) {
+		panic("boom")
+	}).Then(func(ret interface{}) (interface{}, error) {
+		t.Fatal("then after panic")
+		return nil, nil
+	}).Done(func(ret interface{}, err error) {
+		final = err
+	})
+	if pe, ok := final.(*PanicError); !ok || pe.Value != "boom" {
+		t.Fatalf("panic: %v", final)
+	}
+}
+
+func TestSkeletonFuture(t *testing.T) {
+	s := &Skeleton{GoLen: 10, TimerDispatcherLen: 10, AsynCallLen: 10, ChanRPCServer: chanrpc.NewServer(10)}
+	s.Init()
+
+	guild := chanrpc.NewServer(10)
+	guild.Register("guild", func(args []interface{}) interface{} {
+		return "guild of " + args[0].(string)
+	})
+	go func() {
+		for ci := range guild.ChanCall {
+			guild.Exec(ci)
+		}
+	}()
+
+	result := make(chan interface{}, 1)
+	s.RegisterChanRPC("login", func(args []interface{}) {
+		s.GoFuture(func() (interface{}, error) {
+			return "player", nil // load from db
+		}).Then(func(ret interface{}) (interface{}, error) {
+			return All(s.AsynCallFuture(guild, "guild", ret), s.AfterFuture(time.Millisecond)), nil
+		}).Done(func(ret interface{}, err error) {
+			if err != nil {
+				result <- err
+				return
+			}
+			result <- ret.([]interface{})[0]
+		})
+	})
+
+	closeSig := make(chan bool)
+	go s.Run(closeSig)
+	defer func() { closeSig <- true }()
+
+	s.ChanRPCServer.Go("login")
+	select {
+	case ret := <-result:
+		if ret != "guild of player" {
+			t.Fatalf("unexpected result %v", ret)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+}
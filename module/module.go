@@ -1,8 +1,10 @@
 package module
 
 import (
+	"fmt"
 	"runtime"
 	"sync"
+	"time"
 
 	"github.com/jiangzuomin/leaf/config"
 	"github.com/jiangzuomin/leaf/log"
@@ -15,8 +17,33 @@ type Module interface {
 	Run(closeSig chan bool)
 }
 
+// 以下为可选接口
+
+// Named gives a module the name other modules use in Dependencies,
+// the name defaults to the module type (e.g. "*game.Module")
+type Named interface {
+	Name() string
+}
+
+// Depender lists the names of modules that must be initialized before it
+type Depender interface {
+	Dependencies() []string
+}
+
+// Starter is called after all modules are initialized and before any Run
+type Starter interface {
+	OnStart()
+}
+
+// Stopper is called before any module receives closeSig,
+// the method is called while modules are running and must be goroutine safe
+type Stopper interface {
+	OnStopping()
+}
+
 type module struct {
 	mi       Module
+	name     string
 	closeSig chan bool
 	wg       sync.WaitGroup
 }
@@ -26,16 +53,37 @@ var mods []*module
 func Register(mi Module) {
 	m := new(module)
 	m.mi = mi
+	m.name = moduleName(mi)
 	m.closeSig = make(chan bool, 1)
 
 	mods = append(mods, m)
 }
 
+func moduleName(mi Module) string {
+	if n, ok := mi.(Named); ok {
+		return n.Name()
+	}
+	return fmt.Sprintf("%T", mi)
+}
+
+// Init 按依赖关系排序模块, 依次调用 OnInit, OnStart, 再启动各模块的 Run
 func Init() {
+	sorted, err := sortModules(mods)
+	if err != nil {
+		log.Log.WithField("err", err).Fatal("invalid module dependencies")
+	}
+	mods = sorted
+
 	for i := 0; i < len(mods); i++ {
 		mods[i].mi.OnInit()
 	}
 
+	for i := 0; i < len(mods); i++ {
+		if s, ok := mods[i].mi.(Starter); ok {
+			s.OnStart()
+		}
+	}
+
 	for i := 0; i < len(mods); i++ {
 		m := mods[i]
 		m.wg.Add(1)
@@ -43,12 +91,44 @@ func Init() {
 	}
 }
 
+// 模块 Run 超过 config.ShutdownTimeout 仍未返回时放弃等待, 不再调用其 OnDestroy
 func Destroy() {
+	for i := len(mods) - 1; i >= 0; i-- {
+		if s, ok := mods[i].mi.(Stopper); ok {
+			safeCall(s.OnStopping)
+		}
+	}
+
 	for i := len(mods) - 1; i >= 0; i-- {
 		m := mods[i]
 		m.closeSig <- true
+		if !wait(m, config.ShutdownTimeout) {
+			log.Log.WithFields(logrus.Fields{"module": m.name, "timeout": config.ShutdownTimeout}).Error("module is stuck, skip it")
+			continue
+		}
+		safeCall(m.mi.OnDestroy)
+	}
+
+	mods = nil
+}
+
+func wait(m *module, timeout time.Duration) bool {
+	if timeout <= 0 {
+		m.wg.Wait()
+		return true
+	}
+
+	done := make(chan struct{})
+	go func() {
 		m.wg.Wait()
-		destroy(m)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
 	}
 }
 
@@ -57,7 +137,8 @@ func run(m *module) {
 	m.wg.Done()
 }
 
-func destroy(m *module) {
+// 调用模块的钩子, panic 只记录日志, 不影响其他模块的关闭
+func safeCall(f func()) {
 	defer func() {
 		if r := recover(); r != nil {
 			if config.LenStackBuf > 0 {
@@ -70,5 +151,5 @@ func destroy(m *module) {
 		}
 	}()
 
-	m.mi.OnDestroy()
+	f()
 }
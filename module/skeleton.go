@@ -1,23 +1,43 @@
 package module
 
 import (
+	"context"
 	"github.com/jiangzuomin/leaf/chanrpc"
+	"github.com/jiangzuomin/leaf/cluster"
+	"github.com/jiangzuomin/leaf/config"
 	"github.com/jiangzuomin/leaf/console"
 	"github.com/jiangzuomin/leaf/go"
+	"github.com/jiangzuomin/leaf/internal/relay"
+	"github.com/jiangzuomin/leaf/log"
+	"github.com/jiangzuomin/leaf/metrics"
 	"github.com/jiangzuomin/leaf/timer"
+	"github.com/sirupsen/logrus"
 	"time"
 )
 
 type Skeleton struct {
 	GoLen              int
+	GoWorkers          int // 大于 0 时 Go 使用固定数量的 goroutine 执行
+	GoQueueLen         int // GoWorkers 大于 0 时的等待队列长度
 	TimerDispatcherLen int
+	TimerWheel         *timer.Wheel    // 不为 nil 时定时器使用时间轮
+	TimerClock         timer.Clock     // 不为 nil 时定时器使用该时钟 (优先于 TimerWheel), 如测试中的 timer.FakeClock
+	CronStore          timer.CronStore // Schedule 使用, 为 nil 时使用 config.CronStorePath 文件, 都没有时不持久化
 	AsynCallLen        int
+	PostLen            int // Post 的通道长度
 	ChanRPCServer      *chanrpc.Server
+	Metrics            metrics.Recorder // 不为 nil 时记录事件循环的指标, 如 metrics.Default
+	MetricsName        string           // 指标中的模块名, 默认为 skeleton
 	g                  *g.Go
+	pool               *g.Pool
+	keyed              []*g.KeyedContext
 	dispatcher         *timer.Dispatcher
+	scheduler          *timer.Scheduler
 	client             *chanrpc.Client
 	server             *chanrpc.Server
 	commandServer      *chanrpc.Server
+	chanPost           chan func()
+	posts              *relay.Relay[func()]
 }
 
 func (s *Skeleton) Init() {
@@ -30,9 +50,21 @@ func (s *Skeleton) Init() {
 	if s.AsynCallLen <= 0 {
 		s.AsynCallLen = 0
 	}
+	if s.PostLen <= 0 {
+		s.PostLen = 0
+	}
 
 	s.g = g.New(s.GoLen)
-	s.dispatcher = timer.NewDispatcher(s.TimerDispatcherLen)
+	if s.GoWorkers > 0 {
+		s.pool = s.g.NewPool(s.GoWorkers, s.GoQueueLen)
+	}
+	if s.TimerClock != nil {
+		s.dispatcher = timer.NewClockDispatcher(s.TimerDispatcherLen, s.TimerClock)
+	} else if s.TimerWheel != nil {
+		s.dispatcher = timer.NewWheelDispatcher(s.TimerDispatcherLen, s.TimerWheel)
+	} else {
+		s.dispatcher = timer.NewDispatcher(s.TimerDispatcherLen)
+	}
 	s.client = chanrpc.NewClient(s.AsynCallLen)
 	s.server = s.ChanRPCServer
 
@@ -40,33 +72,113 @@ func (s *Skeleton) Init() {
 		s.server = chanrpc.NewServer(0)
 	}
 	s.commandServer = chanrpc.NewServer(0)
+	s.chanPost = make(chan func(), s.PostLen)
+	s.posts = relay.New(s.chanPost)
+
+	if s.MetricsName == "" {
+		s.MetricsName = "skeleton"
+	}
 }
 
 func (s *Skeleton) Run(closeSig chan bool) {
+	var deadline <-chan time.Time
+	closing := false
 	for {
+		if closing && s.idle() {
+			s.close()
+			return
+		}
+
 		select {
 		case <-closeSig:
-			s.commandServer.Close()
-			s.server.Close()
-			for !s.g.Idle() || !s.client.Idle() {
-				s.g.Close()
-				s.client.Close()
+			// 关闭前继续处理已排队的调用, 回调和已触发的定时器, 直到空闲或超时
+			closing, closeSig = true, nil
+			if config.ShutdownTimeout > 0 {
+				t := time.NewTimer(config.ShutdownTimeout)
+				defer t.Stop()
+				deadline = t.C
 			}
+		case <-deadline:
+			log.Log.WithFields(logrus.Fields{"module": s.MetricsName, "timeout": config.ShutdownTimeout}).Error("skeleton is not drained, close it")
+			s.close()
 			return
 		case ri := <-s.client.ChanAsynRet:
+			start := s.begin()
 			s.client.Cb(ri)
+			s.end("asynret", nil, len(s.client.ChanAsynRet), start)
 		case ci := <-s.server.ChanCall:
+			start := s.begin()
 			s.server.Exec(ci)
+			s.end("call", ci.ID(), len(s.server.ChanCall), start)
 		case ci := <-s.commandServer.ChanCall:
+			start := s.begin()
 			s.commandServer.Exec(ci)
+			s.end("command", ci.ID(), len(s.commandServer.ChanCall), start)
 		case cb := <-s.g.ChanCb:
+			start := s.begin()
 			s.g.Cb(cb)
+			s.end("cb", nil, len(s.g.ChanCb), start)
 		case t := <-s.dispatcher.ChanTimer:
+			start := s.begin()
 			t.Cb()
+			s.end("timer", nil, len(s.dispatcher.ChanTimer), start)
+		case f := <-s.chanPost:
+			start := s.begin()
+			safeCall(f)
+			s.end("post", nil, len(s.chanPost), start)
 		}
 	}
 }
 
+// 没有排队的事件, 也没有等待回调的 Go 和 AsynCall, 尚未触发的定时器不计入
+func (s *Skeleton) idle() bool {
+	return len(s.server.ChanCall) == 0 &&
+		len(s.commandServer.ChanCall) == 0 &&
+		len(s.client.ChanAsynRet) == 0 &&
+		len(s.g.ChanCb) == 0 &&
+		len(s.chanPost) == 0 && s.posts.Len() == 0 &&
+		s.dispatcher.Len() == 0 &&
+		s.g.Idle() && s.client.Idle()
+}
+
+func (s *Skeleton) close() {
+	s.commandServer.Close()
+	s.server.Close()
+	for !s.g.Idle() || !s.client.Idle() {
+		s.g.Close()
+		s.client.Close()
+	}
+	if s.pool != nil {
+		s.pool.Close()
+	}
+	for _, c := range s.keyed {
+		c.Close()
+	}
+}
+
+func (s *Skeleton) begin() time.Time {
+	if s.Metrics == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// 记录一个事件的处理时间和该来源剩余的事件数
+func (s *Skeleton) end(source string, id interface{}, depth int, start time.Time) {
+	if s.Metrics == nil {
+		return
+	}
+
+	s.Metrics.Observe(s.MetricsName, source, id, time.Since(start))
+	s.Metrics.QueueDepth(s.MetricsName, source, depth)
+}
+
+// Now returns the time of the timer clock, game logic should use it instead of
+// time.Now to be testable with a fake clock
+func (s *Skeleton) Now() time.Time {
+	return s.dispatcher.Now()
+}
+
 func (s *Skeleton) AfterFunc(d time.Duration, cb func()) *timer.Timer {
 	if s.TimerDispatcherLen == 0 {
 		panic("invalid TimerDispatcherLen")
@@ -75,6 +187,14 @@ func (s *Skeleton) AfterFunc(d time.Duration, cb func()) *timer.Timer {
 	return s.dispatcher.AfterFunc(d, cb)
 }
 
+func (s *Skeleton) TickFunc(interval time.Duration, cb func()) *timer.Timer {
+	if s.TimerDispatcherLen == 0 {
+		panic("invalid TimerDispatcherLen")
+	}
+
+	return s.dispatcher.TickFunc(interval, cb)
+}
+
 func (s *Skeleton) CronFunc(cronExpr *timer.CronExpr, cb func()) *timer.Cron {
 	if s.TimerDispatcherLen == 0 {
 		panic("invalid TimerDispatcherLen")
@@ -83,12 +203,62 @@ func (s *Skeleton) CronFunc(cronExpr *timer.CronExpr, cb func()) *timer.Cron {
 	return s.dispatcher.CronFunc(cronExpr, cb)
 }
 
+func (s *Skeleton) CronFuncIn(cronExpr *timer.CronExpr, loc *time.Location, cb func()) *timer.Cron {
+	if s.TimerDispatcherLen == 0 {
+		panic("invalid TimerDispatcherLen")
+	}
+
+	return s.dispatcher.CronFuncIn(cronExpr, loc, cb)
+}
+
+// Schedule runs a named cron job whose last run is persisted in CronStore,
+// the runs missed while the server was down are handled according to policy.
+// Without CronStore and config.CronStorePath nothing is persisted or caught up.
+func (s *Skeleton) Schedule(name string, cronExpr *timer.CronExpr, policy timer.CatchUp, cb func(time.Time)) (*timer.Job, error) {
+	if s.TimerDispatcherLen == 0 {
+		panic("invalid TimerDispatcherLen")
+	}
+
+	if s.scheduler == nil {
+		if s.CronStore == nil && config.CronStorePath != "" {
+			store, err := timer.NewFileStore(config.CronStorePath)
+			if err != nil {
+				return nil, err
+			}
+			s.CronStore = store
+		}
+		s.scheduler = timer.NewScheduler(s.dispatcher, s.CronStore)
+	}
+
+	return s.scheduler.Schedule(name, cronExpr, policy, cb)
+}
+
 func (s *Skeleton) Go(f func(), cb func()) {
 	if s.GoLen == 0 {
 		panic("invalid GoLen")
 	}
 
+	if s.pool != nil {
+		s.pool.Go(f, cb)
+		return
+	}
+
+	s.g.Go(f, cb)
+}
+
+// TryGo is Go but returns g.ErrPoolFull instead of waiting when the queue
+// of the pool (GoWorkers > 0) is full
+func (s *Skeleton) TryGo(f func(), cb func()) error {
+	if s.GoLen == 0 {
+		panic("invalid GoLen")
+	}
+
+	if s.pool != nil {
+		return s.pool.TryGo(f, cb)
+	}
+
 	s.g.Go(f, cb)
+	return nil
 }
 
 func (s *Skeleton) NewLinearContext() *g.LinearContext {
@@ -99,6 +269,18 @@ func (s *Skeleton) NewLinearContext() *g.LinearContext {
 	return s.g.NewLinearContext()
 }
 
+// NewKeyedContext returns a context running at most limit keys at once on its
+// own workers, they are stopped when Run returns
+func (s *Skeleton) NewKeyedContext(limit int) *g.KeyedContext {
+	if s.GoLen == 0 {
+		panic("invalid GoLen")
+	}
+
+	c := s.g.NewKeyedContext(limit)
+	s.keyed = append(s.keyed, c)
+	return c
+}
+
 func (s *Skeleton) AsynCall(server *chanrpc.Server, id interface{}, args ...interface{}) {
 	if s.AsynCallLen == 0 {
 		panic("invalid AsynCallLen")
@@ -108,6 +290,42 @@ func (s *Skeleton) AsynCall(server *chanrpc.Server, id interface{}, args ...inte
 	s.client.AsynCall(id, args...)
 }
 
+func (s *Skeleton) AsynCallContext(ctx context.Context, server *chanrpc.Server, id interface{}, args ...interface{}) {
+	if s.AsynCallLen == 0 {
+		panic("invalid AsynCallLen")
+	}
+
+	s.client.Attach(server)
+	s.client.AsynCallContext(ctx, id, args...)
+}
+
+func (s *Skeleton) AsynCallTimeout(d time.Duration, server *chanrpc.Server, id interface{}, args ...interface{}) {
+	if s.AsynCallLen == 0 {
+		panic("invalid AsynCallLen")
+	}
+
+	s.client.Attach(server)
+	s.client.AsynCallTimeout(d, id, args...)
+}
+
+// AsynCall calls a typed chanrpc method, cb is executed on the skeleton goroutine
+func AsynCall[Req, Resp any](s *Skeleton, ctx context.Context, server *chanrpc.Server, m chanrpc.Method[Req, Resp], req Req, cb func(Resp, error)) {
+	if s.AsynCallLen == 0 {
+		panic("invalid AsynCallLen")
+	}
+
+	s.client.Attach(server)
+	m.AsynCall(ctx, s.client, req, cb)
+}
+
+func (s *Skeleton) ClusterAsynCall(node string, service string, id interface{}, args ...interface{}) {
+	if s.AsynCallLen == 0 {
+		panic("invalid AsynCallLen")
+	}
+
+	cluster.AsynCall(s.client, node, service, id, args...)
+}
+
 func (s *Skeleton) RegisterChanRPC(id interface{}, f interface{}) {
 	if s.ChanRPCServer == nil {
 		panic("invalid ChanRPCServer")
@@ -116,6 +334,13 @@ func (s *Skeleton) RegisterChanRPC(id interface{}, f interface{}) {
 	s.server.Register(id, f)
 }
 
+// Post runs f on the skeleton goroutine, it never blocks and can be called
+// from any goroutine, e.g. in a recordfile.RecordFile.OnReload handler
+// goroutine safe
+func (s *Skeleton) Post(f func()) {
+	s.posts.Post(f)
+}
+
 func (s *Skeleton) RegisterCommand(name string, help string, f interface{}) {
 	console.Register(name, help, f, s.commandServer)
 }
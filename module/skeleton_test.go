@@ -0,0 +1,136 @@
+package module
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jiangzuomin/leaf/chanrpc"
+	"github.com/jiangzuomin/leaf/config"
+	"github.com/jiangzuomin/leaf/recordfile"
+	"github.com/jiangzuomin/leaf/timer"
+)
+
+func TestScheduleDefaultStore(t *testing.T) {
+	defer func(path string) { config.CronStorePath = path }(config.CronStorePath)
+	config.CronStorePath = filepath.Join(t.TempDir(), "cron.json")
+
+	cronExpr, err := timer.NewCronExpr("@daily")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := timer.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	for _, name := range []string{"login.reset", "game.reset"} {
+		s := &Skeleton{TimerDispatcherLen: 10, TimerClock: clock}
+		s.Init()
+		if _, err := s.Schedule(name, cronExpr, timer.CatchUpOnce, func(time.Time) {}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// what the next process would read
+	data, err := os.ReadFile(config.CronStorePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var runs map[string]time.Time
+	if err := json.Unmarshal(data, &runs); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"login.reset", "game.reset"} {
+		if _, ok := runs[name]; !ok {
+			t.Fatalf("last run of %v lost: %s", name, data)
+		}
+	}
+}
+
+func TestPostOnReload(t *testing.T) {
+	type Drop struct {
+		ID   int `index:""`
+		Rate float64
+	}
+
+	name := filepath.Join(t.TempDir(), "drop.txt")
+	if err := os.WriteFile(name, []byte("ID\tRate\n1\t0.5\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rf, err := recordfile.New(Drop{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rf.Read(name); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Skeleton{ChanRPCServer: chanrpc.NewServer(10)}
+	s.Init()
+	reloaded := make(chan float64, 1)
+	rf.OnReload(func(old *recordfile.RecordFile, new *recordfile.RecordFile) {
+		s.Post(func() {
+			reloaded <- new.Index(1).(*Drop).Rate
+		})
+	})
+	// reload triggered on the skeleton goroutine
+	s.RegisterChanRPC("reload", func(args []interface{}) {
+		if err := os.WriteFile(name, []byte("ID\tRate\n1\t0.8\n"), 0644); err != nil {
+			t.Error(err)
+		}
+		if err := rf.Reload(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	closeSig := make(chan bool)
+	go s.Run(closeSig)
+	defer func() { closeSig <- true }()
+
+	s.ChanRPCServer.Go("reload")
+	select {
+	case rate := <-reloaded:
+		if rate != 0.8 {
+			t.Fatalf("unexpected rate %v", rate)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("reload handler deadlocked")
+	}
+}
+
+func TestRunDrain(t *testing.T) {
+	s := &Skeleton{TimerDispatcherLen: 10, ChanRPCServer: chanrpc.NewServer(10)}
+	s.Init()
+
+	release := make(chan bool)
+	var called, fired bool
+	s.RegisterChanRPC("block", func(args []interface{}) {
+		s.AfterFunc(time.Millisecond, func() { fired = true })
+		<-release
+	})
+	s.RegisterChanRPC("call", func(args []interface{}) {
+		called = true
+	})
+
+	closeSig := make(chan bool, 1)
+	done := make(chan bool)
+	go func() {
+		s.Run(closeSig)
+		close(done)
+	}()
+
+	// 关闭时调用和已触发的定时器仍在队列中
+	s.ChanRPCServer.Go("block")
+	s.ChanRPCServer.Go("call")
+	closeSig <- true
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return")
+	}
+	if !called || !fired {
+		t.Fatalf("called %v, fired %v", called, fired)
+	}
+}
@@ -0,0 +1,97 @@
+package module
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/jiangzuomin/leaf/chanrpc"
+	"github.com/jiangzuomin/leaf/config"
+	"github.com/jiangzuomin/leaf/log"
+	"github.com/sirupsen/logrus"
+)
+
+// Task runs sequential code that can wait for asynchronous results without
+// blocking the skeleton. The task has its own goroutine but only runs while
+// the skeleton goroutine waits for it: when it waits for a result the
+// skeleton serves other events, and it is resumed by the skeleton callback of
+// the result. So a task may use the module state like any handler.
+// goroutine not safe, use it inside its task function
+type Task struct {
+	s      *Skeleton
+	resume chan struct{}
+	yield  chan struct{}
+}
+
+// Task starts f as a task and returns when it finishes or first waits.
+// Tasks still waiting when the skeleton closes are not resumed.
+func (s *Skeleton) Task(f func(t *Task)) {
+	t := &Task{s: s, resume: make(chan struct{}), yield: make(chan struct{})}
+
+	go func() {
+		<-t.resume
+		defer func() {
+			if r := recover(); r != nil {
+				if config.LenStackBuf > 0 {
+					buf := make([]byte, config.LenStackBuf)
+					l := runtime.Stack(buf, false)
+					log.Log.WithFields(logrus.Fields{"recover": r, "buf": buf[:l]}).Error()
+				} else {
+					log.Log.WithField("recover", r).Error()
+				}
+			}
+			t.yield <- struct{}{}
+		}()
+
+		f(t)
+	}()
+	t.run()
+}
+
+// RegisterTask registers a chanrpc handler running as a task, the handler
+// has no return value as the caller is answered before the task finishes
+func (s *Skeleton) RegisterTask(id interface{}, f func(t *Task, args []interface{})) {
+	s.RegisterChanRPC(id, func(args []interface{}) {
+		s.Task(func(t *Task) {
+			f(t, args)
+		})
+	})
+}
+
+// 在 skeleton 的 goroutine 上执行, 直到 task 等待或结束
+func (t *Task) run() {
+	t.resume <- struct{}{}
+	<-t.yield
+}
+
+// 在 task 的 goroutine 上执行, 把控制权交还 skeleton 直到被恢复
+func (t *Task) suspend() {
+	t.yield <- struct{}{}
+	<-t.resume
+}
+
+// Await waits for f to be resolved
+func (t *Task) Await(f *Future) (interface{}, error) {
+	if !f.IsDone() {
+		f.Done(func(interface{}, error) {
+			t.run()
+		})
+		t.suspend()
+	}
+
+	return f.Result()
+}
+
+// Call1 calls id of server like Skeleton.AsynCall and waits for the result
+func (t *Task) Call1(server *chanrpc.Server, id interface{}, args ...interface{}) (interface{}, error) {
+	return t.Await(t.s.AsynCallFuture(server, id, args...))
+}
+
+// Go executes f on another goroutine like Skeleton.Go and waits for it
+func (t *Task) Go(f func() (interface{}, error)) (interface{}, error) {
+	return t.Await(t.s.GoFuture(f))
+}
+
+// Sleep waits for d on the skeleton timers
+func (t *Task) Sleep(d time.Duration) {
+	t.Await(t.s.AfterFuture(d))
+}
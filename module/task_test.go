@@ -0,0 +1,57 @@
+package module
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jiangzuomin/leaf/chanrpc"
+)
+
+func TestTask(t *testing.T) {
+	s := &Skeleton{GoLen: 10, TimerDispatcherLen: 10, AsynCallLen: 10, ChanRPCServer: chanrpc.NewServer(10)}
+	s.Init()
+
+	release := make(chan struct{})
+	guild := chanrpc.NewServer(10)
+	guild.Register("guild", func(args []interface{}) interface{} {
+		<-release
+		return "guild of " + args[0].(string)
+	})
+	go func() {
+		for ci := range guild.ChanCall {
+			guild.Exec(ci)
+		}
+	}()
+
+	pings := 0
+	s.RegisterChanRPC("ping", func(args []interface{}) {
+		pings++
+		close(release)
+	})
+
+	result := make(chan []interface{}, 1)
+	s.RegisterTask("login", func(task *Task, args []interface{}) {
+		player, _ := task.Go(func() (interface{}, error) {
+			return "player", nil
+		})
+		// the skeleton serves ping while the task waits
+		guild, err := task.Call1(guild, "guild", player)
+		task.Sleep(time.Millisecond)
+		result <- []interface{}{guild, err, pings}
+	})
+
+	closeSig := make(chan bool)
+	go s.Run(closeSig)
+	defer func() { closeSig <- true }()
+
+	s.ChanRPCServer.Go("login")
+	s.ChanRPCServer.Go("ping")
+	select {
+	case ret := <-result:
+		if ret[0] != "guild of player" || ret[1] != nil || ret[2] != 1 {
+			t.Fatalf("unexpected result %v", ret)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+}
@@ -0,0 +1,16 @@
+package network
+
+// Envelope carries the sequence number of a message in the envelope mode of
+// the processors. A request of the client has a sequence number, the replies
+// to it carry the same number and messages pushed by the server carry 0.
+// Unmarshal returns an Envelope in envelope mode and Marshal accepts one, a
+// message without Envelope is marshaled as a push.
+type Envelope struct {
+	Seq uint32
+	Msg interface{}
+}
+
+// Reply returns msg as a reply to the request with seq
+func Reply(seq uint32, msg interface{}) Envelope {
+	return Envelope{Seq: seq, Msg: msg}
+}
@@ -6,11 +6,20 @@ import (
 	"fmt"
 	"github.com/jiangzuomin/leaf/chanrpc"
 	"github.com/jiangzuomin/leaf/log"
+	"github.com/jiangzuomin/leaf/network"
 	"reflect"
 )
 
 type Processor struct {
-	msgInfo map[string]*MsgInfo
+	msgInfo     map[string]*MsgInfo
+	middlewares []network.Middleware
+	envelope    bool
+}
+
+// envelope 模式: {"seq": 1, "msg": {"Login": {...}}}
+type envelope struct {
+	Seq uint32      `json:"seq"`
+	Msg interface{} `json:"msg"`
 }
 
 type MsgInfo struct {
@@ -18,8 +27,11 @@ type MsgInfo struct {
 	msgRouter     *chanrpc.Server
 	msgHandler    MsgHandler
 	msgRawHandler MsgHandler
+	middlewares   []network.Middleware
 }
 
+// args: msg, userData, *network.MsgContext
+// raw args: msgID, msgRawData, userData, *network.MsgContext
 type MsgHandler func([]interface{})
 
 type MsgRaw struct {
@@ -33,6 +45,12 @@ func NewProcessor() *Processor {
 	return p
 }
 
+// SetEnvelope turns on the envelope mode, see network.Envelope
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) SetEnvelope(envelope bool) {
+	p.envelope = envelope
+}
+
 // It's dangerous to call the method on routing or marshaling (unmarshaling)
 func (p *Processor) Register(msg interface{}) string {
 	// 获取接口的实际类型
@@ -98,18 +116,49 @@ func (p *Processor) SetRawHandler(msgID string, msgRawHandler MsgHandler) {
 	i.msgRawHandler = msgRawHandler
 }
 
+// Use adds middlewares run before the handler and router of every message
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) Use(middlewares ...network.Middleware) {
+	p.middlewares = append(p.middlewares, middlewares...)
+}
+
+// UseFor adds middlewares run after the global ones for messages of the type of msg
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) UseFor(msg interface{}, middlewares ...network.Middleware) {
+	msgType := reflect.TypeOf(msg)
+	if msgType == nil || msgType.Kind() != reflect.Ptr {
+		log.Log.Fatal("json message pointer required")
+	}
+	msgID := msgType.Elem().Name()
+	i, ok := p.msgInfo[msgID]
+	if !ok {
+		log.Log.WithField("MsgID", msgID).Fatal("message not registered")
+	}
+
+	i.middlewares = append(i.middlewares, middlewares...)
+}
+
 // goroutine safe
 func (p *Processor) Route(msg interface{}, userData interface{}) error {
+	var seq uint32
+	if env, ok := msg.(network.Envelope); ok {
+		seq = env.Seq
+		msg = env.Msg
+	}
+
 	// raw
 	if msgRaw, ok := msg.(MsgRaw); ok {
 		i, ok := p.msgInfo[msgRaw.msgID]
 		if !ok {
 			return fmt.Errorf("message %v not registered", msgRaw.msgID)
 		}
-		if i.msgRawHandler != nil {
-			i.msgRawHandler([]interface{}{msgRaw.msgID, msgRaw.msgRawData, userData})
-		}
-		return nil
+		ctx := &network.MsgContext{MsgID: msgRaw.msgID, Msg: msgRaw, UserData: userData, Seq: seq}
+		return network.RunMiddlewares(ctx, p.middlewares, i.middlewares, func() error {
+			if i.msgRawHandler != nil {
+				i.msgRawHandler([]interface{}{msgRaw.msgID, msgRaw.msgRawData, userData, ctx})
+			}
+			return nil
+		})
 	}
 
 	// json
@@ -122,17 +171,40 @@ func (p *Processor) Route(msg interface{}, userData interface{}) error {
 	if !ok {
 		return fmt.Errorf("message %v not registered", msgID)
 	}
-	if i.msgHandler != nil {
-		i.msgHandler([]interface{}{msg, userData})
-	}
-	if i.msgRouter != nil {
-		i.msgRouter.Go(msgType, msg, userData)
-	}
-	return nil
+	ctx := &network.MsgContext{MsgID: msgID, Msg: msg, UserData: userData, Seq: seq}
+	return network.RunMiddlewares(ctx, p.middlewares, i.middlewares, func() error {
+		if i.msgHandler != nil {
+			i.msgHandler([]interface{}{msg, userData, ctx})
+		}
+		if i.msgRouter != nil {
+			i.msgRouter.Go(msgType, msg, userData, ctx)
+		}
+		return nil
+	})
 }
 
 // goroutine safe
 func (p *Processor) Unmarshal(data []byte) (interface{}, error) {
+	if !p.envelope {
+		return p.unmarshal(data)
+	}
+
+	var env struct {
+		Seq uint32          `json:"seq"`
+		Msg json.RawMessage `json:"msg"`
+	}
+	err := json.Unmarshal(data, &env)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := p.unmarshal(env.Msg)
+	if err != nil {
+		return nil, err
+	}
+	return network.Envelope{Seq: env.Seq, Msg: msg}, nil
+}
+
+func (p *Processor) unmarshal(data []byte) (interface{}, error) {
 	var m map[string]json.RawMessage
 	err := json.Unmarshal(data, &m)
 	if err != nil {
@@ -162,6 +234,12 @@ func (p *Processor) Unmarshal(data []byte) (interface{}, error) {
 
 // goroutine safe
 func (p *Processor) Marshal(msg interface{}) ([][]byte, error) {
+	var seq uint32
+	if env, ok := msg.(network.Envelope); ok {
+		seq = env.Seq
+		msg = env.Msg
+	}
+
 	msgType := reflect.TypeOf(msg)
 	if msgType == nil || msgType.Kind() != reflect.Ptr {
 		return nil, errors.New("json message pointer required")
@@ -172,7 +250,10 @@ func (p *Processor) Marshal(msg interface{}) ([][]byte, error) {
 	}
 
 	// data
-	m := map[string]interface{}{msgID: msg}
+	var m interface{} = map[string]interface{}{msgID: msg}
+	if p.envelope {
+		m = envelope{Seq: seq, Msg: m}
+	}
 	data, err := json.Marshal(m)
 	return [][]byte{data}, err
 }
@@ -0,0 +1,130 @@
+package json
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jiangzuomin/leaf/network"
+)
+
+type Login struct{ Name string }
+type Move struct{ X, Y int }
+type Error struct{ Reason string }
+
+type testAgent struct {
+	user    string
+	written []interface{}
+}
+
+func (a *testAgent) WriteMsg(msg interface{}) {
+	a.written = append(a.written, msg)
+}
+
+func TestMiddleware(t *testing.T) {
+	p := NewProcessor()
+	p.Register(&Login{})
+	p.Register(&Move{})
+	p.Register(&Error{})
+
+	var trace []string
+	p.Use(func(ctx *network.MsgContext, next func() error) error {
+		trace = append(trace, "log "+ctx.MsgID.(string))
+		return next()
+	})
+	p.UseFor(&Move{}, func(ctx *network.MsgContext, next func() error) error {
+		a := ctx.UserData.(*testAgent)
+		if a.user == "" {
+			ctx.Reply(&Error{Reason: "login first"})
+			return nil
+		}
+		ctx.Set("user", a.user)
+		return next()
+	})
+	p.UseFor(&Login{}, func(ctx *network.MsgContext, next func() error) error {
+		if ctx.Msg.(*Login).Name == "" {
+			return errors.New("bad login")
+		}
+		return next()
+	})
+
+	p.SetHandler(&Login{}, func(args []interface{}) {
+		args[1].(*testAgent).user = args[0].(*Login).Name
+	})
+	p.SetHandler(&Move{}, func(args []interface{}) {
+		user, _ := args[2].(*network.MsgContext).Value("user")
+		trace = append(trace, "move "+user.(string))
+	})
+
+	a := new(testAgent)
+	route := func(data string) error {
+		msg, err := p.Unmarshal([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p.Route(msg, a)
+	}
+
+	if err := route(`{"Move":{"X":1}}`); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.written) != 1 || a.written[0].(*Error).Reason != "login first" {
+		t.Fatalf("unexpected reply %v", a.written)
+	}
+	if err := route(`{"Login":{}}`); err == nil {
+		t.Fatal("empty login should fail")
+	}
+	if err := route(`{"Login":{"Name":"leaf"}}`); err != nil {
+		t.Fatal(err)
+	}
+	if err := route(`{"Move":{"X":1}}`); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"log Move", "log Login", "log Login", "log Move", "move leaf"}
+	if len(trace) != len(want) {
+		t.Fatalf("trace %v, want %v", trace, want)
+	}
+	for i := range want {
+		if trace[i] != want[i] {
+			t.Fatalf("trace %v, want %v", trace, want)
+		}
+	}
+}
+
+func TestEnvelope(t *testing.T) {
+	p := NewProcessor()
+	p.SetEnvelope(true)
+	p.Register(&Login{})
+	p.Register(&Error{})
+	p.SetHandler(&Login{}, func(args []interface{}) {
+		args[2].(*network.MsgContext).Reply(&Error{Reason: "busy"})
+	})
+
+	msg, err := p.Unmarshal([]byte(`{"seq":7,"msg":{"Login":{"Name":"leaf"}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := new(testAgent)
+	if err := p.Route(msg, a); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.written) != 1 {
+		t.Fatalf("unexpected reply %v", a.written)
+	}
+
+	for _, tt := range []struct {
+		msg  interface{}
+		data string
+	}{
+		{a.written[0], `{"seq":7,"msg":{"Error":{"Reason":"busy"}}}`},
+		{&Error{Reason: "kicked"}, `{"seq":0,"msg":{"Error":{"Reason":"kicked"}}}`},
+	} {
+		data, err := p.Marshal(tt.msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data[0]) != tt.data {
+			t.Fatalf("marshal %s, want %s", data[0], tt.data)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package network
+
+// MsgContext is the message being routed by a processor, it is passed to the
+// middlewares and appended to the handler and router arguments
+type MsgContext struct {
+	MsgID    interface{} // json 为 string, protobuf 为 uint16
+	Msg      interface{} // raw 消息为 processor 的 MsgRaw
+	UserData interface{} // 通常为 gate.Agent
+	Seq      uint32      // envelope 模式下请求的序号, 0 为无序号
+	values   map[interface{}]interface{}
+}
+
+// Middleware runs before the message handler and router. It calls next to
+// continue the chain; returning without calling next drops the message (reply
+// with ctx.Reply first if the client should know why), returning an error
+// makes Route fail and the gate closes the connection.
+type Middleware func(ctx *MsgContext, next func() error) error
+
+// Set stores a value for the later middlewares and the handlers
+func (ctx *MsgContext) Set(key interface{}, value interface{}) {
+	if ctx.values == nil {
+		ctx.values = make(map[interface{}]interface{})
+	}
+	ctx.values[key] = value
+}
+
+func (ctx *MsgContext) Value(key interface{}) (interface{}, bool) {
+	v, ok := ctx.values[key]
+	return v, ok
+}
+
+// Reply writes msg to UserData if it can write messages (e.g. gate.Agent),
+// as a reply to the request if it has a sequence number
+// goroutine safe if UserData.WriteMsg is
+func (ctx *MsgContext) Reply(msg interface{}) bool {
+	w, ok := ctx.UserData.(interface{ WriteMsg(msg interface{}) })
+	if ok {
+		if ctx.Seq != 0 {
+			w.WriteMsg(Reply(ctx.Seq, msg))
+		} else {
+			w.WriteMsg(msg)
+		}
+	}
+	return ok
+}
+
+// RunMiddlewares runs the global then the per message type middlewares and
+// finally f
+func RunMiddlewares(ctx *MsgContext, global []Middleware, perType []Middleware, f func() error) error {
+	if len(global) == 0 && len(perType) == 0 {
+		return f()
+	}
+
+	var next func(i int) error
+	next = func(i int) error {
+		switch {
+		case i < len(global):
+			return global[i](ctx, func() error { return next(i + 1) })
+		case i < len(global)+len(perType):
+			return perType[i-len(global)](ctx, func() error { return next(i + 1) })
+		default:
+			return f()
+		}
+	}
+	return next(0)
+}
@@ -0,0 +1,319 @@
+package msgpack
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+
+	"github.com/jiangzuomin/leaf/chanrpc"
+	"github.com/jiangzuomin/leaf/log"
+	"github.com/jiangzuomin/leaf/network"
+	"github.com/sirupsen/logrus"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// a message is a sequence of msgpack values:
+// -------------------------
+// | id | msgpack message  |
+// -------------------------
+// id is a uint (1 byte below 128) or the message name in string id mode
+// envelope 模式:
+// -------------------------------
+// | id | seq | msgpack message  |
+// -------------------------------
+type Processor struct {
+	stringID    bool
+	envelope    bool
+	nextID      uint16 // Register 按顺序分配的下一个 id
+	msgInfo     map[uint16]*MsgInfo
+	msgName     map[string]*MsgInfo
+	msgType     map[reflect.Type]*MsgInfo
+	middlewares []network.Middleware
+}
+
+type MsgInfo struct {
+	msgID         uint16
+	msgName       string
+	msgType       reflect.Type
+	msgRouter     *chanrpc.Server
+	msgHandler    MsgHandler
+	msgRawHandler MsgHandler
+	middlewares   []network.Middleware
+}
+
+// args: msg, userData, *network.MsgContext
+// raw args: msgID, msgRawData, userData, *network.MsgContext
+type MsgHandler func([]interface{})
+
+type MsgRaw struct {
+	msgID      uint16
+	msgRawData []byte
+}
+
+func NewProcessor() *Processor {
+	p := new(Processor)
+	p.msgInfo = make(map[uint16]*MsgInfo)
+	p.msgName = make(map[string]*MsgInfo)
+	p.msgType = make(map[reflect.Type]*MsgInfo)
+	return p
+}
+
+// SetStringID puts the message name instead of the numeric id in the header
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) SetStringID(stringID bool) {
+	p.stringID = stringID
+}
+
+// SetEnvelope turns on the envelope mode, a uint sequence number follows
+// the id, see network.Envelope
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) SetEnvelope(envelope bool) {
+	p.envelope = envelope
+}
+
+// Register assigns the lowest free id in registration order, use RegisterID
+// for ids which must not depend on the order
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) Register(msg interface{}) uint16 {
+	for {
+		if _, ok := p.msgInfo[p.nextID]; !ok {
+			break
+		}
+		if p.nextID == math.MaxUint16 {
+			log.Log.WithField("max", math.MaxUint16).Fatal("too many msgpack messages")
+		}
+		p.nextID++
+	}
+
+	return p.RegisterID(msg, p.nextID)
+}
+
+// RegisterID registers msg with an explicit id, its name is the type name
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) RegisterID(msg interface{}, id uint16) uint16 {
+	msgType := reflect.TypeOf(msg)
+	if msgType == nil || msgType.Kind() != reflect.Ptr {
+		log.Log.Fatal("msgpack message pointer required")
+	}
+	msgName := msgType.Elem().Name()
+	if msgName == "" {
+		log.Log.Fatal("unnamed msgpack message")
+	}
+	if _, ok := p.msgType[msgType]; ok {
+		log.Log.WithField("msgType", msgType).Fatal("message is already registered")
+	}
+	if i, ok := p.msgName[msgName]; ok {
+		log.Log.WithFields(logrus.Fields{"MsgName": msgName, "msgType": msgType, "registered": i.msgType}).Fatal("message name collision")
+	}
+	if i, ok := p.msgInfo[id]; ok {
+		log.Log.WithFields(logrus.Fields{"MsgID": id, "msgType": msgType, "registered": i.msgType}).Fatal("message id collision")
+	}
+
+	i := new(MsgInfo)
+	i.msgID = id
+	i.msgName = msgName
+	i.msgType = msgType
+	p.msgInfo[id] = i
+	p.msgName[msgName] = i
+	p.msgType[msgType] = i
+	return id
+}
+
+func (p *Processor) info(msg interface{}) *MsgInfo {
+	msgType := reflect.TypeOf(msg)
+	i, ok := p.msgType[msgType]
+	if !ok {
+		log.Log.WithField("msgType", msgType).Fatal("message is not registered")
+	}
+
+	return i
+}
+
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) SetRouter(msg interface{}, msgRouter *chanrpc.Server) {
+	p.info(msg).msgRouter = msgRouter
+}
+
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) SetHandler(msg interface{}, msgHandler MsgHandler) {
+	p.info(msg).msgHandler = msgHandler
+}
+
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) SetRawHandler(id uint16, msgRawHandler MsgHandler) {
+	i, ok := p.msgInfo[id]
+	if !ok {
+		log.Log.WithField("MsgID", id).Fatal("message is not registered")
+	}
+
+	i.msgRawHandler = msgRawHandler
+}
+
+// Use adds middlewares run before the handler and router of every message
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) Use(middlewares ...network.Middleware) {
+	p.middlewares = append(p.middlewares, middlewares...)
+}
+
+// UseFor adds middlewares run after the global ones for messages of the type of msg
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) UseFor(msg interface{}, middlewares ...network.Middleware) {
+	i := p.info(msg)
+	i.middlewares = append(i.middlewares, middlewares...)
+}
+
+// goroutine safe
+func (p *Processor) Route(msg interface{}, userData interface{}) error {
+	var seq uint32
+	if env, ok := msg.(network.Envelope); ok {
+		seq = env.Seq
+		msg = env.Msg
+	}
+
+	// raw
+	if msgRaw, ok := msg.(MsgRaw); ok {
+		i, ok := p.msgInfo[msgRaw.msgID]
+		if !ok {
+			return fmt.Errorf("message id %v not registered", msgRaw.msgID)
+		}
+		ctx := &network.MsgContext{MsgID: msgRaw.msgID, Msg: msgRaw, UserData: userData, Seq: seq}
+		return network.RunMiddlewares(ctx, p.middlewares, i.middlewares, func() error {
+			if i.msgRawHandler != nil {
+				i.msgRawHandler([]interface{}{msgRaw.msgID, msgRaw.msgRawData, userData, ctx})
+			}
+			return nil
+		})
+	}
+
+	// msgpack
+	msgType := reflect.TypeOf(msg)
+	i, ok := p.msgType[msgType]
+	if !ok {
+		return fmt.Errorf("message %v not registered", msgType)
+	}
+	ctx := &network.MsgContext{MsgID: i.msgID, Msg: msg, UserData: userData, Seq: seq}
+	return network.RunMiddlewares(ctx, p.middlewares, i.middlewares, func() error {
+		if i.msgHandler != nil {
+			i.msgHandler([]interface{}{msg, userData, ctx})
+		}
+		if i.msgRouter != nil {
+			i.msgRouter.Go(msgType, msg, userData, ctx)
+		}
+		return nil
+	})
+}
+
+// goroutine safe
+func (p *Processor) Unmarshal(data []byte) (interface{}, error) {
+	r := bytes.NewReader(data)
+	dec := msgpack.NewDecoder(r)
+
+	// id
+	var i *MsgInfo
+	if p.stringID {
+		msgName, err := dec.DecodeString()
+		if err != nil {
+			return nil, err
+		}
+		var ok bool
+		i, ok = p.msgName[msgName]
+		if !ok {
+			return nil, fmt.Errorf("message %v not registered", msgName)
+		}
+	} else {
+		id, err := dec.DecodeUint16()
+		if err != nil {
+			return nil, err
+		}
+		var ok bool
+		i, ok = p.msgInfo[id]
+		if !ok {
+			return nil, fmt.Errorf("message id %v not registered", id)
+		}
+	}
+
+	// seq
+	var seq uint32
+	if p.envelope {
+		var err error
+		seq, err = dec.DecodeUint32()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// msg, bytes.Reader 不经过缓冲, 剩余部分即消息
+	data = data[len(data)-r.Len():]
+	var msg interface{}
+	if i.msgRawHandler != nil {
+		msg = MsgRaw{i.msgID, data}
+	} else {
+		msg = reflect.New(i.msgType.Elem()).Interface()
+		if err := msgpack.Unmarshal(data, msg); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.envelope {
+		return network.Envelope{Seq: seq, Msg: msg}, nil
+	}
+	return msg, nil
+}
+
+// goroutine safe
+func (p *Processor) Marshal(msg interface{}) ([][]byte, error) {
+	var seq uint32
+	if env, ok := msg.(network.Envelope); ok {
+		seq = env.Seq
+		msg = env.Msg
+	}
+	msgType := reflect.TypeOf(msg)
+	if msgType == nil || msgType.Kind() != reflect.Ptr {
+		return nil, errors.New("msgpack message pointer required")
+	}
+	i, ok := p.msgType[msgType]
+	if !ok {
+		return nil, fmt.Errorf("message %v not registered", msgType)
+	}
+
+	// id
+	var header bytes.Buffer
+	enc := msgpack.NewEncoder(&header)
+	var err error
+	if p.stringID {
+		err = enc.EncodeString(i.msgName)
+	} else {
+		err = enc.EncodeUint(uint64(i.msgID))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// seq
+	if p.envelope {
+		if err := enc.EncodeUint(uint64(seq)); err != nil {
+			return nil, err
+		}
+	}
+
+	// data
+	data, err := msgpack.Marshal(msg)
+	return [][]byte{header.Bytes(), data}, err
+}
+
+// Range calls f in ascending id order
+// goroutine safe
+func (p *Processor) Range(f func(id uint16, t reflect.Type)) {
+	ids := make([]uint16, 0, len(p.msgInfo))
+	for id := range p.msgInfo {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	for _, id := range ids {
+		f(id, p.msgInfo[id].msgType)
+	}
+}
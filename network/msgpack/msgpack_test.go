@@ -0,0 +1,69 @@
+package msgpack
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/jiangzuomin/leaf/network"
+)
+
+type Hello struct {
+	Name string `msgpack:"name"`
+}
+
+type Move struct {
+	X, Y int
+}
+
+func TestProcessor(t *testing.T) {
+	for _, stringID := range []bool{false, true} {
+		p := NewProcessor()
+		p.SetStringID(stringID)
+		p.SetEnvelope(true)
+		p.Register(&Hello{})
+		p.RegisterID(&Move{}, 300)
+
+		data, err := p.Marshal(network.Reply(5, &Hello{Name: "leaf"}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		header := []byte{0x00, 0x05} // fixint id, fixint seq
+		if stringID {
+			header = []byte{0xa5, 'H', 'e', 'l', 'l', 'o', 0x05}
+		}
+		if !bytes.Equal(data[0], header) {
+			t.Fatalf("string id %v: header %x", stringID, data[0])
+		}
+
+		msg, err := p.Unmarshal(bytes.Join(data, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var name string
+		var seq uint32
+		p.SetHandler(&Hello{}, func(args []interface{}) {
+			name = args[0].(*Hello).Name
+			seq = args[2].(*network.MsgContext).Seq
+		})
+		if err := p.Route(msg, nil); err != nil || name != "leaf" || seq != 5 {
+			t.Fatalf("string id %v: route %v, name %q, seq %v", stringID, err, name, seq)
+		}
+
+		// raw
+		var raw []byte
+		p.SetRawHandler(300, func(args []interface{}) {
+			raw = args[1].([]byte)
+		})
+		data, err = p.Marshal(&Move{X: 1, Y: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, err = p.Unmarshal(bytes.Join(data, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Route(msg, nil); err != nil || !bytes.Equal(raw, data[1]) {
+			t.Fatalf("string id %v: raw route %v, %x", stringID, err, raw)
+		}
+	}
+}
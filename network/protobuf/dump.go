@@ -0,0 +1,100 @@
+package protobuf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// Dump decodes data as received by Unmarshal for logging. Registered
+// messages are printed with their fields, the others as raw wire fields.
+// goroutine safe
+func (p *Processor) Dump(data []byte) string {
+	header := 2
+	if p.envelope {
+		header = 6
+	}
+	if len(data) < header {
+		return fmt.Sprintf("<too short: %x>", data)
+	}
+
+	var id uint16
+	var seq uint32
+	if p.littleEndian {
+		id = binary.LittleEndian.Uint16(data)
+		if p.envelope {
+			seq = binary.LittleEndian.Uint32(data[2:])
+		}
+	} else {
+		id = binary.BigEndian.Uint16(data)
+		if p.envelope {
+			seq = binary.BigEndian.Uint32(data[2:])
+		}
+	}
+
+	prefix := fmt.Sprintf("#%v", id)
+	if p.envelope {
+		prefix += fmt.Sprintf(" seq %v", seq)
+	}
+
+	data = data[header:]
+	i, ok := p.msgInfo[id]
+	if !ok {
+		return prefix + " unknown {" + dumpWire(data) + "}"
+	}
+
+	msg := i.msgType.New().Interface()
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return fmt.Sprintf("%v %v <%v> {%v}", prefix, i.msgType.Descriptor().FullName(), err, dumpWire(data))
+	}
+	return fmt.Sprintf("%v %v {%v}", prefix, i.msgType.Descriptor().FullName(), prototext.MarshalOptions{}.Format(msg))
+}
+
+// 按 wire 格式列出字段: 编号:值
+func dumpWire(data []byte) string {
+	var fields []string
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return strings.Join(append(fields, "<invalid>"), " ")
+		}
+		data = data[n:]
+
+		var v string
+		switch typ {
+		case protowire.VarintType:
+			var x uint64
+			x, n = protowire.ConsumeVarint(data)
+			v = fmt.Sprint(x)
+		case protowire.Fixed32Type:
+			var x uint32
+			x, n = protowire.ConsumeFixed32(data)
+			v = fmt.Sprintf("0x%08x", x)
+		case protowire.Fixed64Type:
+			var x uint64
+			x, n = protowire.ConsumeFixed64(data)
+			v = fmt.Sprintf("0x%016x", x)
+		case protowire.BytesType:
+			var b []byte
+			b, n = protowire.ConsumeBytes(data)
+			if len(b) > 32 {
+				v = fmt.Sprintf("%q...(%v bytes)", b[:32], len(b))
+			} else {
+				v = fmt.Sprintf("%q", b)
+			}
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+			v = "group"
+		}
+		if n < 0 {
+			return strings.Join(append(fields, "<invalid>"), " ")
+		}
+		data = data[n:]
+		fields = append(fields, fmt.Sprintf("%v:%v", num, v))
+	}
+	return strings.Join(fields, " ")
+}
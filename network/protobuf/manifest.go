@@ -0,0 +1,76 @@
+package protobuf
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ManifestEntry is the id of one message, a manifest lists all the messages
+// of a processor in ascending id order
+type ManifestEntry struct {
+	ID   uint16 `json:"id"`
+	Name string `json:"name"` // protobuf full name
+}
+
+// Manifest returns the id table, e.g. for the client teams
+// goroutine safe
+func (p *Processor) Manifest() []ManifestEntry {
+	var manifest []ManifestEntry
+	for _, id := range p.ids() {
+		manifest = append(manifest, ManifestEntry{ID: id, Name: string(p.msgInfo[id].msgType.Descriptor().FullName())})
+	}
+	return manifest
+}
+
+// WriteManifest writes the manifest as JSON
+// goroutine safe
+func (p *Processor) WriteManifest(w io.Writer) error {
+	data, err := json.MarshalIndent(p.Manifest(), "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// ReadManifest reads a manifest written by WriteManifest
+func ReadManifest(r io.Reader) ([]ManifestEntry, error) {
+	var manifest []ManifestEntry
+	err := json.NewDecoder(r).Decode(&manifest)
+	return manifest, err
+}
+
+// CheckManifest returns an error listing every message whose id differs
+// from manifest (e.g. the one of another build) or which is only on one side
+// goroutine safe
+func (p *Processor) CheckManifest(manifest []ManifestEntry) error {
+	ids := make(map[string]uint16)
+	for _, e := range p.Manifest() {
+		ids[e.Name] = e.ID
+	}
+
+	var diffs []string
+	seen := make(map[string]bool)
+	for _, e := range manifest {
+		seen[e.Name] = true
+		id, ok := ids[e.Name]
+		if !ok {
+			diffs = append(diffs, fmt.Sprintf("%v: not registered (manifest id %v)", e.Name, e.ID))
+		} else if id != e.ID {
+			diffs = append(diffs, fmt.Sprintf("%v: id %v, manifest id %v", e.Name, id, e.ID))
+		}
+	}
+	for _, e := range p.Manifest() {
+		if !seen[e.Name] {
+			diffs = append(diffs, fmt.Sprintf("%v: id %v, not in manifest", e.Name, e.ID))
+		}
+	}
+
+	if len(diffs) > 0 {
+		return errors.New("protobuf id table mismatch:\n" + strings.Join(diffs, "\n"))
+	}
+	return nil
+}
@@ -4,29 +4,48 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"github.com/golang/protobuf/proto"
 	"github.com/jiangzuomin/leaf/chanrpc"
 	"github.com/jiangzuomin/leaf/log"
+	"github.com/jiangzuomin/leaf/network"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"hash/fnv"
 	"math"
 	"reflect"
+	"sort"
 )
 
 // -------------------------
 // | id | protobuf message |
 // -------------------------
+// envelope 模式:
+// -------------------------------
+// | id | seq | protobuf message |
+// -------------------------------
 type Processor struct {
 	littleEndian bool
-	msgInfo      []*MsgInfo
-	msgID        map[reflect.Type]uint16
+	envelope     bool
+	msgInfo      map[uint16]*MsgInfo
+	nextID       uint16 // Register 按顺序分配的下一个 id
+	msgID        map[protoreflect.FullName]uint16
+	middlewares  []network.Middleware
 }
 
 type MsgInfo struct {
-	msgType       reflect.Type
+	msgType       protoreflect.MessageType
+	goType        reflect.Type
+	routerID      interface{} // 编译生成的类型为 reflect.Type, 动态消息为 full name
 	msgRouter     *chanrpc.Server
 	msgHandler    MsgHandler
 	msgRawHandler MsgHandler
+	middlewares   []network.Middleware
 }
 
+// args: msg, userData, *network.MsgContext
+// raw args: msgID, msgRawData, userData, *network.MsgContext
 type MsgHandler func([]interface{})
 
 type MsgRaw struct {
@@ -37,7 +56,8 @@ type MsgRaw struct {
 func NewProcessor() *Processor {
 	p := new(Processor)
 	p.littleEndian = false
-	p.msgID = make(map[reflect.Type]uint16)
+	p.msgInfo = make(map[uint16]*MsgInfo)
+	p.msgID = make(map[protoreflect.FullName]uint16)
 	return p
 }
 
@@ -46,86 +66,255 @@ func (p *Processor) SetByteOrder(littleEndian bool) {
 	p.littleEndian = littleEndian
 }
 
+// SetEnvelope turns on the envelope mode, a uint32 sequence number follows
+// the id, see network.Envelope
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) SetEnvelope(envelope bool) {
+	p.envelope = envelope
+}
+
+// Register assigns the lowest free id in registration order, the wire ids
+// change if the order does, prefer RegisterID, RegisterHash or RegisterOption
+// for released clients
 // It's dangerous to call the method on routing or marshaling (unmarshaling)
 func (p *Processor) Register(msg proto.Message) uint16 {
-	msgType := reflect.TypeOf(msg)
-	if msgType == nil || msgType.Kind() != reflect.Ptr {
-		log.Log.Fatal("protobuf message pointer required")
+	return p.RegisterID(msg, p.freeID())
+}
+
+// RegisterID registers msg with an explicit id
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) RegisterID(msg proto.Message, id uint16) uint16 {
+	if msg == nil {
+		log.Log.Fatal("protobuf message required")
+	}
+
+	return p.register(msg.ProtoReflect().Type(), id)
+}
+
+// RegisterHash registers msg with an id derived from its protobuf full name,
+// so it does not depend on the registration order. A collision is fatal,
+// register one of the messages with RegisterID then.
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) RegisterHash(msg proto.Message) uint16 {
+	if msg == nil {
+		log.Log.Fatal("protobuf message required")
+	}
+
+	return p.RegisterID(msg, HashID(string(msg.ProtoReflect().Descriptor().FullName())))
+}
+
+// RegisterOption registers msg with the id set by the message option xt:
+//
+//	extend google.protobuf.MessageOptions { uint32 msg_id = 50000; }
+//	message Login { option (msg_id) = 1; }
+//
+//	p.RegisterOption(&msg.Login{}, msg.E_MsgId)
+//
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) RegisterOption(msg proto.Message, xt protoreflect.ExtensionType) uint16 {
+	if msg == nil {
+		log.Log.Fatal("protobuf message required")
 	}
-	if _, ok := p.msgID[msgType]; ok {
-		log.Log.WithField("msgType", msgType).Fatal("message is already registered")
+
+	id, err := OptionID(msg.ProtoReflect().Descriptor(), xt)
+	if err != nil {
+		log.Log.WithField("err", err).Fatal("protobuf message id option")
 	}
-	if len(p.msgInfo) >= math.MaxUint16 {
-		log.Log.WithField("max", math.MaxUint16).Fatal("too many protobuf messages")
+	return p.register(msg.ProtoReflect().Type(), id)
+}
+
+// OptionID returns the id set by the integer message option xt on md
+func OptionID(md protoreflect.MessageDescriptor, xt protoreflect.ExtensionType) (uint16, error) {
+	xd := xt.TypeDescriptor()
+	opts, _ := md.Options().(proto.Message)
+	if opts == nil || !proto.HasExtension(opts, xt) {
+		return 0, fmt.Errorf("message %v: option %v not set", md.FullName(), xd.FullName())
 	}
 
-	i := new(MsgInfo)
-	i.msgType = msgType
-	p.msgInfo = append(p.msgInfo, i)
-	id := uint16(len(p.msgInfo) - 1)
-	p.msgID[msgType] = id
-	return id
+	var id uint64
+	v := opts.ProtoReflect().Get(xd)
+	switch xd.Kind() {
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		if v.Int() < 0 {
+			return 0, fmt.Errorf("message %v: invalid id %v", md.FullName(), v.Int())
+		}
+		id = uint64(v.Int())
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind,
+		protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		id = v.Uint()
+	default:
+		return 0, fmt.Errorf("option %v: %v is not an integer", xd.FullName(), xd.Kind())
+	}
+	if id > math.MaxUint16 {
+		return 0, fmt.Errorf("message %v: invalid id %v", md.FullName(), id)
+	}
+	return uint16(id), nil
 }
 
+// RegisterName registers the message with the full name name with id, the
+// message type is found in protoregistry.GlobalTypes, or built dynamically
+// from the descriptor in protoregistry.GlobalFiles (e.g. loaded from a
+// descriptor set) when no Go type is compiled in
 // It's dangerous to call the method on routing or marshaling (unmarshaling)
-func (p *Processor) SetRouter(msg proto.Message, msgRouter *chanrpc.Server) {
-	msgType := reflect.TypeOf(msg)
-	id, ok := p.msgID[msgType]
-	if !ok {
-		log.Log.WithField("msgType", msgType).Fatal("message is not registered")
+func (p *Processor) RegisterName(name protoreflect.FullName, id uint16) uint16 {
+	if mt, err := protoregistry.GlobalTypes.FindMessageByName(name); err == nil {
+		return p.register(mt, id)
 	}
 
-	p.msgInfo[id].msgRouter = msgRouter
+	d, err := protoregistry.GlobalFiles.FindDescriptorByName(name)
+	if err != nil {
+		log.Log.WithFields(logrus.Fields{"name": name, "err": err}).Fatal("protobuf message not found")
+	}
+	md, ok := d.(protoreflect.MessageDescriptor)
+	if !ok {
+		log.Log.WithField("name", name).Fatal("not a protobuf message")
+	}
+	return p.RegisterDescriptor(md, id)
 }
 
+// RegisterDescriptor registers a message without a compiled Go type, it is
+// decoded as a *dynamicpb.Message and routed with its full name as chanrpc id
 // It's dangerous to call the method on routing or marshaling (unmarshaling)
-func (p *Processor) SetHandler(msg proto.Message, msgHandler MsgHandler) {
-	msgType := reflect.TypeOf(msg)
-	id, ok := p.msgID[msgType]
+func (p *Processor) RegisterDescriptor(md protoreflect.MessageDescriptor, id uint16) uint16 {
+	return p.register(dynamicpb.NewMessageType(md), id)
+}
+
+func (p *Processor) register(mt protoreflect.MessageType, id uint16) uint16 {
+	name := mt.Descriptor().FullName()
+	if _, ok := p.msgID[name]; ok {
+		log.Log.WithField("name", name).Fatal("message is already registered")
+	}
+	if i, ok := p.msgInfo[id]; ok {
+		log.Log.WithFields(logrus.Fields{"MsgID": id, "name": name, "registered": i.msgType.Descriptor().FullName()}).Fatal("message id collision")
+	}
+
+	i := new(MsgInfo)
+	i.msgType = mt
+	i.goType = reflect.TypeOf(mt.Zero().Interface())
+	if _, ok := mt.Zero().Interface().(*dynamicpb.Message); ok {
+		i.routerID = name
+	} else {
+		i.routerID = i.goType
+	}
+	p.msgInfo[id] = i
+	p.msgID[name] = id
+	return id
+}
+
+// Register 按顺序分配的 id
+func (p *Processor) freeID() uint16 {
+	for {
+		if _, ok := p.msgInfo[p.nextID]; !ok {
+			return p.nextID
+		}
+		if p.nextID == math.MaxUint16 {
+			log.Log.WithField("max", math.MaxUint16).Fatal("too many protobuf messages")
+		}
+		p.nextID++
+	}
+}
+
+func (p *Processor) info(msg proto.Message) *MsgInfo {
+	if msg == nil {
+		log.Log.Fatal("protobuf message required")
+	}
+	name := msg.ProtoReflect().Descriptor().FullName()
+	id, ok := p.msgID[name]
 	if !ok {
-		log.Log.WithField("msgType", msgType).Fatal("message is not registered")
+		log.Log.WithField("name", name).Fatal("message is not registered")
 	}
 
-	p.msgInfo[id].msgHandler = msgHandler
+	return p.msgInfo[id]
+}
+
+// HashID returns the id RegisterHash uses for a protobuf full name
+func HashID(name string) uint16 {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	sum := h.Sum32()
+	return uint16(sum>>16 ^ sum)
+}
+
+// The chanrpc id is the reflect.Type of msg, or its full name for a message
+// registered by descriptor (msg may be a dynamicpb.NewMessage then)
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) SetRouter(msg proto.Message, msgRouter *chanrpc.Server) {
+	p.info(msg).msgRouter = msgRouter
+}
+
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) SetHandler(msg proto.Message, msgHandler MsgHandler) {
+	p.info(msg).msgHandler = msgHandler
 }
 
 // It's dangerous to call the method on routing or marshaling (unmarshaling)
 func (p *Processor) SetRawHandler(id uint16, msgRawHandler MsgHandler) {
-	if id >= uint16(len(p.msgInfo)) {
+	i, ok := p.msgInfo[id]
+	if !ok {
 		log.Log.WithField("MsgID", id).Fatal("message is not registered")
 	}
 
-	p.msgInfo[id].msgRawHandler = msgRawHandler
+	i.msgRawHandler = msgRawHandler
+}
+
+// Use adds middlewares run before the handler and router of every message
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) Use(middlewares ...network.Middleware) {
+	p.middlewares = append(p.middlewares, middlewares...)
+}
+
+// UseFor adds middlewares run after the global ones for messages of the type of msg
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) UseFor(msg proto.Message, middlewares ...network.Middleware) {
+	i := p.info(msg)
+	i.middlewares = append(i.middlewares, middlewares...)
 }
 
 // goroutine safe
 func (p *Processor) Route(msg interface{}, userData interface{}) error {
+	var seq uint32
+	if env, ok := msg.(network.Envelope); ok {
+		seq = env.Seq
+		msg = env.Msg
+	}
+
 	// raw
 	if msgRaw, ok := msg.(MsgRaw); ok {
-		if msgRaw.msgID >= uint16(len(p.msgInfo)) {
+		i, ok := p.msgInfo[msgRaw.msgID]
+		if !ok {
 			return fmt.Errorf("message id %v not registered", msgRaw.msgID)
 		}
-		i := p.msgInfo[msgRaw.msgID]
-		if i.msgRawHandler != nil {
-			i.msgRawHandler([]interface{}{msgRaw.msgID, msgRaw.msgRawData, userData})
-		}
-		return nil
+		ctx := &network.MsgContext{MsgID: msgRaw.msgID, Msg: msgRaw, UserData: userData, Seq: seq}
+		return network.RunMiddlewares(ctx, p.middlewares, i.middlewares, func() error {
+			if i.msgRawHandler != nil {
+				i.msgRawHandler([]interface{}{msgRaw.msgID, msgRaw.msgRawData, userData, ctx})
+			}
+			return nil
+		})
 	}
 
 	// protobuf
-	msgType := reflect.TypeOf(msg)
-	id, ok := p.msgID[msgType]
+	m, ok := msg.(proto.Message)
 	if !ok {
-		return fmt.Errorf("message %s not registered", msgType)
+		return fmt.Errorf("message %T is not a protobuf message", msg)
 	}
-	i := p.msgInfo[id]
-	if i.msgHandler != nil {
-		i.msgHandler([]interface{}{msg, userData})
-	}
-	if i.msgRouter != nil {
-		i.msgRouter.Go(msgType, msg, userData)
+	name := m.ProtoReflect().Descriptor().FullName()
+	id, ok := p.msgID[name]
+	if !ok {
+		return fmt.Errorf("message %v not registered", name)
 	}
-	return nil
+	i := p.msgInfo[id]
+	ctx := &network.MsgContext{MsgID: id, Msg: msg, UserData: userData, Seq: seq}
+	return network.RunMiddlewares(ctx, p.middlewares, i.middlewares, func() error {
+		if i.msgHandler != nil {
+			i.msgHandler([]interface{}{msg, userData, ctx})
+		}
+		if i.msgRouter != nil {
+			i.msgRouter.Go(i.routerID, msg, userData, ctx)
+		}
+		return nil
+	})
 }
 
 // goroutine safe
@@ -141,46 +330,107 @@ func (p *Processor) Unmarshal(data []byte) (interface{}, error) {
 	} else {
 		id = binary.BigEndian.Uint16(data)
 	}
-	if id >= uint16(len(p.msgInfo)) {
+	i, ok := p.msgInfo[id]
+	if !ok {
 		return nil, fmt.Errorf("message id %v not registered", id)
 	}
 
 	// msg
-	i := p.msgInfo[id]
+	if !p.envelope {
+		return p.unmarshal(i, id, data[2:])
+	}
+
+	// seq
+	if len(data) < 6 {
+		return nil, errors.New("protobuf data too short")
+	}
+	var seq uint32
+	if p.littleEndian {
+		seq = binary.LittleEndian.Uint32(data[2:])
+	} else {
+		seq = binary.BigEndian.Uint32(data[2:])
+	}
+	msg, err := p.unmarshal(i, id, data[6:])
+	if err != nil {
+		return nil, err
+	}
+	return network.Envelope{Seq: seq, Msg: msg}, nil
+}
+
+func (p *Processor) unmarshal(i *MsgInfo, id uint16, data []byte) (interface{}, error) {
 	if i.msgRawHandler != nil {
-		return MsgRaw{id, data[2:]}, nil
+		return MsgRaw{id, data}, nil
 	} else {
-		msg := reflect.New(i.msgType.Elem()).Interface()
-		return msg, proto.UnmarshalMerge(data[2:], msg.(proto.Message))
+		msg := i.msgType.New().Interface()
+		return msg, proto.UnmarshalOptions{Merge: true}.Unmarshal(data, msg)
 	}
 }
 
 // goroutine safe
 func (p *Processor) Marshal(msg interface{}) ([][]byte, error) {
-	msgType := reflect.TypeOf(msg)
+	var seq uint32
+	if env, ok := msg.(network.Envelope); ok {
+		seq = env.Seq
+		msg = env.Msg
+	}
+	m, ok := msg.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("message %T is not a protobuf message", msg)
+	}
 
 	// id
-	_id, ok := p.msgID[msgType]
+	name := m.ProtoReflect().Descriptor().FullName()
+	_id, ok := p.msgID[name]
 	if !ok {
-		err := fmt.Errorf("message %s not registered", msgType)
+		err := fmt.Errorf("message %v not registered", name)
 		return nil, err
 	}
 
 	id := make([]byte, 2)
+	if p.envelope {
+		id = make([]byte, 6)
+	}
 	if p.littleEndian {
 		binary.LittleEndian.PutUint16(id, _id)
 	} else {
 		binary.BigEndian.PutUint16(id, _id)
 	}
 
+	// seq
+	if p.envelope {
+		if p.littleEndian {
+			binary.LittleEndian.PutUint32(id[2:], seq)
+		} else {
+			binary.BigEndian.PutUint32(id[2:], seq)
+		}
+	}
+
 	// data
-	data, err := proto.Marshal(msg.(proto.Message))
+	data, err := proto.Marshal(m)
 	return [][]byte{id, data}, err
 }
 
+// Range calls f in ascending id order
 // goroutine safe
 func (p *Processor) Range(f func(id uint16, t reflect.Type)) {
-	for id, i := range p.msgInfo {
-		f(uint16(id), i.msgType)
+	for _, id := range p.ids() {
+		f(id, p.msgInfo[id].goType)
+	}
+}
+
+// RangeDescriptors calls f with the descriptors in ascending id order
+// goroutine safe
+func (p *Processor) RangeDescriptors(f func(id uint16, md protoreflect.MessageDescriptor)) {
+	for _, id := range p.ids() {
+		f(id, p.msgInfo[id].msgType.Descriptor())
+	}
+}
+
+func (p *Processor) ids() []uint16 {
+	ids := make([]uint16, 0, len(p.msgInfo))
+	for id := range p.msgInfo {
+		ids = append(ids, id)
 	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
 }
\ No newline at end of file
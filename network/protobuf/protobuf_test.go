@@ -0,0 +1,179 @@
+package protobuf
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/jiangzuomin/leaf/network"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestEnvelope(t *testing.T) {
+	p := NewProcessor()
+	p.SetEnvelope(true)
+	p.Register(&wrapperspb.StringValue{})
+
+	data, err := p.Marshal(network.Reply(0x01020304, wrapperspb.String("leaf")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data[0], []byte{0, 0, 1, 2, 3, 4}) {
+		t.Fatalf("unexpected header %v", data[0])
+	}
+
+	msg, err := p.Unmarshal(bytes.Join(data, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, ok := msg.(network.Envelope)
+	if !ok || env.Seq != 0x01020304 || env.Msg.(*wrapperspb.StringValue).Value != "leaf" {
+		t.Fatalf("unexpected message %v", msg)
+	}
+
+	var seq uint32
+	p.SetHandler(&wrapperspb.StringValue{}, func(args []interface{}) {
+		seq = args[2].(*network.MsgContext).Seq
+	})
+	if err := p.Route(msg, nil); err != nil || seq != env.Seq {
+		t.Fatalf("route: %v, seq %v", err, seq)
+	}
+}
+
+func TestStableID(t *testing.T) {
+	p := NewProcessor()
+	if id := p.RegisterID(&wrapperspb.Int32Value{}, 1); id != 1 {
+		t.Fatalf("explicit id %v", id)
+	}
+	if id := p.Register(&wrapperspb.BoolValue{}); id != 0 {
+		t.Fatalf("sequential id %v", id)
+	}
+	if id := p.Register(&wrapperspb.BytesValue{}); id != 2 {
+		t.Fatalf("sequential id %v", id)
+	}
+	name := "google.protobuf.StringValue"
+	if id := p.RegisterHash(&wrapperspb.StringValue{}); id != HashID(name) {
+		t.Fatalf("hash id %v", id)
+	}
+
+	var b bytes.Buffer
+	if err := p.WriteManifest(&b); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := ReadManifest(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest) != 4 || manifest[0].Name != "google.protobuf.BoolValue" {
+		t.Fatalf("unexpected manifest %v", manifest)
+	}
+	if err := p.CheckManifest(manifest); err != nil {
+		t.Fatal(err)
+	}
+
+	// another build registered in a different order
+	other := NewProcessor()
+	other.Register(&wrapperspb.Int32Value{})
+	other.Register(&wrapperspb.BoolValue{})
+	other.RegisterHash(&wrapperspb.StringValue{})
+	err = other.CheckManifest(manifest)
+	if err == nil || !strings.Contains(err.Error(), "google.protobuf.Int32Value: id 0, manifest id 1") ||
+		!strings.Contains(err.Error(), "google.protobuf.BytesValue: not registered") {
+		t.Fatalf("unexpected check error %v", err)
+	}
+}
+
+func TestDescriptor(t *testing.T) {
+	p := NewProcessor()
+	p.RegisterName("google.protobuf.Int32Value", 1)
+	md := (&wrapperspb.StringValue{}).ProtoReflect().Descriptor()
+	p.RegisterDescriptor(md, 2)
+
+	// dynamic message, no compiled type used
+	dyn := dynamicpb.NewMessage(md)
+	dyn.Set(md.Fields().ByName("value"), protoreflect.ValueOfString("leaf"))
+	data, err := p.Marshal(dyn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var value string
+	p.SetHandler(dynamicpb.NewMessage(md), func(args []interface{}) {
+		m := args[0].(protoreflect.ProtoMessage).ProtoReflect()
+		value = m.Get(m.Descriptor().Fields().ByName("value")).String()
+	})
+	msg, err := p.Unmarshal(bytes.Join(data, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Route(msg, nil); err != nil || value != "leaf" {
+		t.Fatalf("route: %v, value %q", err, value)
+	}
+
+	var names []string
+	p.RangeDescriptors(func(id uint16, md protoreflect.MessageDescriptor) {
+		names = append(names, fmt.Sprintf("%v:%v", id, md.FullName()))
+	})
+	if strings.Join(names, " ") != "1:google.protobuf.Int32Value 2:google.protobuf.StringValue" {
+		t.Fatalf("unexpected descriptors %v", names)
+	}
+
+	// prototext output is not stable, check the parts only
+	if s := p.Dump(bytes.Join(data, nil)); !strings.HasPrefix(s, "#2 google.protobuf.StringValue {") || !strings.Contains(s, `"leaf"`) {
+		t.Fatalf("unexpected dump %v", s)
+	}
+	if s := p.Dump(append([]byte{0, 9}, data[1]...)); s != `#9 unknown {1:"leaf"}` {
+		t.Fatalf("unexpected dump %v", s)
+	}
+}
+
+func TestOptionID(t *testing.T) {
+	// extend google.protobuf.MessageOptions { uint32 msg_id = 50000; }
+	xfd, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:       proto.String("leaf/option.proto"),
+		Package:    proto.String("leaf"),
+		Dependency: []string{"google/protobuf/descriptor.proto"},
+		Extension: []*descriptorpb.FieldDescriptorProto{{
+			Name:     proto.String("msg_id"),
+			Number:   proto.Int32(50000),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:     descriptorpb.FieldDescriptorProto_TYPE_UINT32.Enum(),
+			Extendee: proto.String(".google.protobuf.MessageOptions"),
+		}},
+	}, protoregistry.GlobalFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xt := dynamicpb.NewExtensionType(xfd.Extensions().Get(0))
+
+	// message Login { option (msg_id) = 7; }
+	opts := new(descriptorpb.MessageOptions)
+	proto.SetExtension(opts, xt, uint32(7))
+	fd, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:    proto.String("leaf/login.proto"),
+		Package: proto.String("leaf"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{Name: proto.String("Login"), Options: opts},
+			{Name: proto.String("Logout")},
+		},
+	}, protoregistry.GlobalFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewProcessor()
+	login := dynamicpb.NewMessage(fd.Messages().ByName("Login"))
+	if id := p.RegisterOption(login, xt); id != 7 {
+		t.Fatalf("unexpected id %v", id)
+	}
+	if _, err := OptionID(fd.Messages().ByName("Logout"), xt); err == nil {
+		t.Fatal("missing option expected")
+	}
+}
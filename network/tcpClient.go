@@ -105,10 +105,11 @@ reconnect:
 
 	client.Lock()
 	delete(client.conns, conn)
+	closeFlag := client.closeFlag
 	client.Unlock()
 	agent.OnClose()
 
-	if client.AutoReconnect {
+	if client.AutoReconnect && !closeFlag {
 		time.Sleep(client.ConnectInterval)
 		goto reconnect
 	}
@@ -114,9 +114,20 @@ func (server *TCPServer) run() {
 	}
 }
 
-func (server *TCPServer) Close() {
+// ListenAddr returns the address the server listens on after Start, e.g.
+// the port chosen for Addr "127.0.0.1:0"
+func (server *TCPServer) ListenAddr() net.Addr {
+	return server.ln.Addr()
+}
+
+// StopAccept stops accepting new connections, established connections are kept
+func (server *TCPServer) StopAccept() {
 	server.ln.Close()
 	server.wgLn.Wait()
+}
+
+func (server *TCPServer) Close() {
+	server.StopAccept()
 
 	server.mutexConns.Lock()
 	for conn := range server.conns {
@@ -0,0 +1,139 @@
+package network
+
+import (
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/jiangzuomin/leaf/log"
+)
+
+type WebsocketConnSet map[*websocket.Conn]struct{}
+
+type WSConn struct {
+	sync.Mutex
+	conn      *websocket.Conn
+	writeChan chan []byte
+	maxMsgLen uint32
+	closeFlag bool
+}
+
+func newWSConn(conn *websocket.Conn, pendingWriteNum int, maxMsgLen uint32) *WSConn {
+	wsConn := new(WSConn)
+	wsConn.conn = conn
+	wsConn.writeChan = make(chan []byte, pendingWriteNum)
+	wsConn.maxMsgLen = maxMsgLen
+
+	go func() {
+		for b := range wsConn.writeChan {
+			if b == nil {
+				break
+			}
+
+			err := conn.WriteMessage(websocket.BinaryMessage, b)
+			if err != nil {
+				break
+			}
+		}
+
+		conn.Close()
+		wsConn.Lock()
+		wsConn.closeFlag = true
+		wsConn.Unlock()
+	}()
+
+	return wsConn
+}
+
+func (wsConn *WSConn) doDestroy() {
+	// TLS 连接下底层不是 *net.TCPConn
+	if tcpConn, ok := wsConn.conn.UnderlyingConn().(*net.TCPConn); ok {
+		tcpConn.SetLinger(0)
+	}
+	wsConn.conn.Close()
+
+	if !wsConn.closeFlag {
+		close(wsConn.writeChan)
+		wsConn.closeFlag = true
+	}
+}
+
+func (wsConn *WSConn) Destroy() {
+	wsConn.Lock()
+	defer wsConn.Unlock()
+	wsConn.doDestroy()
+}
+
+func (wsConn *WSConn) Close() {
+	wsConn.Lock()
+	defer wsConn.Unlock()
+
+	if wsConn.closeFlag {
+		return
+	}
+
+	wsConn.doWrite(nil)
+	wsConn.closeFlag = true
+}
+
+func (wsConn *WSConn) doWrite(b []byte) {
+	if len(wsConn.writeChan) == cap(wsConn.writeChan) {
+		log.Log.Info("close conn: channel full")
+		wsConn.doDestroy()
+		return
+	}
+
+	wsConn.writeChan <- b
+}
+
+func (wsConn *WSConn) LocalAddr() net.Addr {
+	return wsConn.conn.LocalAddr()
+}
+
+func (wsConn *WSConn) RemoteAddr() net.Addr {
+	return wsConn.conn.RemoteAddr()
+}
+
+// goroutine not safe
+func (wsConn *WSConn) ReadMsg() ([]byte, error) {
+	_, b, err := wsConn.conn.ReadMessage()
+	return b, err
+}
+
+// args must not be modified by the others goroutines
+func (wsConn *WSConn) WriteMsg(args ...[]byte) error {
+	wsConn.Lock()
+	defer wsConn.Unlock()
+	if wsConn.closeFlag {
+		return nil
+	}
+
+	var msgLen uint32
+	for i := 0; i < len(args); i++ {
+		msgLen += uint32(len(args[i]))
+	}
+
+	if msgLen > wsConn.maxMsgLen {
+		return errors.New("Message too long")
+	} else if msgLen < 1 {
+		return errors.New("Message too short")
+	}
+
+	// 单个分片时不拷贝
+	if len(args) == 1 {
+		wsConn.doWrite(args[0])
+		return nil
+	}
+
+	msg := make([]byte, msgLen)
+	l := 0
+	for i := 0; i < len(args); i++ {
+		copy(msg[l:], args[i])
+		l += len(args[i])
+	}
+
+	wsConn.doWrite(msg)
+
+	return nil
+}
@@ -0,0 +1,162 @@
+package network
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jiangzuomin/leaf/log"
+)
+
+type WSServer struct {
+	Addr            string              // 监听网络地址
+	MaxConnNum      int                 // 最大连接数
+	PendingWriteNum int                 // 连接最大可写数
+	MaxMsgLen       uint32              // 消息最大长度
+	HTTPTimeout     time.Duration       // 握手及读写超时
+	CertFile        string              // TLS 证书
+	KeyFile         string              // TLS 私钥
+	NewAgent        func(*WSConn) Agent // 代理
+	ln              net.Listener
+	handler         *WSHandler
+}
+
+type WSHandler struct {
+	maxConnNum      int
+	pendingWriteNum int
+	maxMsgLen       uint32
+	newAgent        func(*WSConn) Agent
+	upgrader        websocket.Upgrader
+	conns           WebsocketConnSet // 连接集合
+	mutexConns      sync.Mutex
+	wg              sync.WaitGroup
+}
+
+func (handler *WSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	conn, err := handler.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Log.WithField("Error", err).Debug("upgrade error")
+		return
+	}
+	conn.SetReadLimit(int64(handler.maxMsgLen))
+
+	handler.wg.Add(1)
+	defer handler.wg.Done()
+
+	handler.mutexConns.Lock()
+	if handler.conns == nil {
+		handler.mutexConns.Unlock()
+		conn.Close()
+		return
+	}
+	if len(handler.conns) >= handler.maxConnNum {
+		handler.mutexConns.Unlock()
+		conn.Close()
+		log.Log.Debug("too many connections")
+		return
+	}
+	handler.conns[conn] = struct{}{}
+	handler.mutexConns.Unlock()
+
+	wsConn := newWSConn(conn, handler.pendingWriteNum, handler.maxMsgLen)
+	agent := handler.newAgent(wsConn)
+	agent.Run()
+
+	wsConn.Close()
+	handler.mutexConns.Lock()
+	delete(handler.conns, conn)
+	handler.mutexConns.Unlock()
+	agent.OnClose()
+}
+
+func (server *WSServer) Start() {
+	ln, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		log.Log.WithField("Error", err).Fatal("Listen Failed!")
+	}
+
+	if server.MaxConnNum <= 0 {
+		server.MaxConnNum = 100
+		log.Log.WithField("MaxConnNum", server.MaxConnNum).Info("Invalid MaxConnNum And Reset")
+	}
+
+	if server.PendingWriteNum <= 0 {
+		server.PendingWriteNum = 100
+		log.Log.WithField("PendingWriteNum", server.PendingWriteNum).Info("Invalid PendingWriteNum And Reset")
+	}
+
+	if server.MaxMsgLen <= 0 {
+		server.MaxMsgLen = 4096
+		log.Log.WithField("MaxMsgLen", server.MaxMsgLen).Info("Invalid MaxMsgLen And Reset")
+	}
+
+	if server.HTTPTimeout <= 0 {
+		server.HTTPTimeout = 10 * time.Second
+		log.Log.WithField("HTTPTimeout", server.HTTPTimeout).Info("Invalid HTTPTimeout And Reset")
+	}
+
+	if server.NewAgent == nil {
+		log.Log.Fatal("NewAgent must not be nil")
+	}
+
+	if server.CertFile != "" || server.KeyFile != "" {
+		config := &tls.Config{}
+		config.NextProtos = []string{"http/1.1"}
+
+		config.Certificates = make([]tls.Certificate, 1)
+		config.Certificates[0], err = tls.LoadX509KeyPair(server.CertFile, server.KeyFile)
+		if err != nil {
+			log.Log.WithField("Error", err).Fatal("Load Certificate Failed!")
+		}
+
+		ln = tls.NewListener(ln, config)
+	}
+
+	server.ln = ln
+	server.handler = &WSHandler{
+		maxConnNum:      server.MaxConnNum,
+		pendingWriteNum: server.PendingWriteNum,
+		maxMsgLen:       server.MaxMsgLen,
+		newAgent:        server.NewAgent,
+		conns:           make(WebsocketConnSet),
+		upgrader: websocket.Upgrader{
+			HandshakeTimeout: server.HTTPTimeout,
+			CheckOrigin:      func(_ *http.Request) bool { return true },
+		},
+	}
+
+	httpServer := &http.Server{
+		Addr:           server.Addr,
+		Handler:        server.handler,
+		ReadTimeout:    server.HTTPTimeout,
+		WriteTimeout:   server.HTTPTimeout,
+		MaxHeaderBytes: 1024,
+	}
+
+	go httpServer.Serve(ln)
+}
+
+// StopAccept stops accepting new connections, established connections are kept
+func (server *WSServer) StopAccept() {
+	server.ln.Close()
+}
+
+func (server *WSServer) Close() {
+	server.StopAccept()
+
+	server.handler.mutexConns.Lock()
+	for conn := range server.handler.conns {
+		conn.Close()
+	}
+	server.handler.conns = nil
+	server.handler.mutexConns.Unlock()
+
+	server.handler.wg.Wait()
+}
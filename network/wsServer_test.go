@@ -0,0 +1,72 @@
+package network
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type echoAgent struct {
+	conn *WSConn
+}
+
+func (a *echoAgent) Run() {
+	for {
+		data, err := a.conn.ReadMsg()
+		if err != nil {
+			return
+		}
+		a.conn.WriteMsg([]byte("echo:"), data)
+	}
+}
+
+func (a *echoAgent) OnClose() {}
+
+func TestWSServer(t *testing.T) {
+	server := new(WSServer)
+	server.Addr = "127.0.0.1:0"
+	server.MaxConnNum = 1
+	server.MaxMsgLen = 16
+	server.NewAgent = func(conn *WSConn) Agent {
+		return &echoAgent{conn: conn}
+	}
+	server.Start()
+	defer server.Close()
+
+	url := "ws://" + server.ln.Addr().String()
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := conn.WriteMessage(websocket.BinaryMessage, []byte("hi")); err != nil {
+		t.Fatal(err)
+	}
+	_, b, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "echo:hi" {
+		t.Fatalf("unexpected reply %q", b)
+	}
+
+	// MaxConnNum
+	conn2, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn2.Close()
+	conn2.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := conn2.ReadMessage(); err == nil {
+		t.Fatal("connection over MaxConnNum should be closed")
+	}
+
+	// MaxMsgLen
+	conn.WriteMessage(websocket.BinaryMessage, make([]byte, 17))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := conn.ReadMessage(); err == nil {
+		t.Fatal("message over MaxMsgLen should close the connection")
+	}
+}
@@ -0,0 +1,188 @@
+package recordfile
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Index tags:
+//
+//	ID    int "index"                 // unique index named "ID" (legacy)
+//	ID    int `index:""`              // same as above
+//	Level int `index:"lc;level,multi"` // part of index "lc", non-unique index "level"
+//	Class int `index:"lc"`            // part of index "lc"
+//
+// Fields sharing an index name form a composite key in field order.
+type indexDef struct {
+	name   string
+	fields []int // field indexes of the key columns
+	multi  bool  // non-unique
+}
+
+// key -> record (unique) or records (non-unique)
+type namedIndex struct {
+	def    *indexDef
+	unique map[interface{}]interface{}
+	multi  map[interface{}][]interface{}
+}
+
+var typeInterface = reflect.TypeOf((*interface{})(nil)).Elem()
+
+func parseIndexDefs(typeRecord reflect.Type) ([]*indexDef, error) {
+	var defs []*indexDef
+	byName := make(map[string]*indexDef)
+
+	for i := 0; i < typeRecord.NumField(); i++ {
+		f := typeRecord.Field(i)
+
+		var entries []string
+		if f.Tag == "index" {
+			entries = []string{""}
+		} else if tag, ok := f.Tag.Lookup("index"); ok {
+			entries = strings.Split(tag, ";")
+		} else {
+			continue
+		}
+
+		if f.PkgPath != "" {
+			return nil, fmt.Errorf("could not index unexported field %v %v", i, f.Name)
+		}
+		switch f.Type.Kind() {
+		case reflect.Struct, reflect.Slice, reflect.Map:
+			return nil, fmt.Errorf("could not index %s field %v %v",
+				f.Type.Kind(), i, f.Name)
+		}
+
+		for _, entry := range entries {
+			opts := strings.Split(entry, ",")
+			name := strings.TrimSpace(opts[0])
+			if name == "" {
+				name = f.Name
+			}
+			multi := false
+			for _, opt := range opts[1:] {
+				switch strings.TrimSpace(opt) {
+				case "multi":
+					multi = true
+				default:
+					return nil, fmt.Errorf("invalid index option %v of field %v", opt, f.Name)
+				}
+			}
+
+			def, ok := byName[name]
+			if !ok {
+				def = &indexDef{name: name, multi: multi}
+				byName[name] = def
+				defs = append(defs, def)
+			} else if def.multi != multi {
+				return nil, fmt.Errorf("index %v: multi option mismatch on field %v", name, f.Name)
+			}
+			def.fields = append(def.fields, i)
+		}
+	}
+
+	return defs, nil
+}
+
+// 单列索引的键为字段值, 多列索引的键为 [n]interface{} 数组
+func indexKey(keys []interface{}) interface{} {
+	if len(keys) == 1 {
+		return keys[0]
+	}
+
+	key := reflect.New(reflect.ArrayOf(len(keys), typeInterface)).Elem()
+	for i, k := range keys {
+		if k != nil {
+			key.Index(i).Set(reflect.ValueOf(k))
+		}
+	}
+	return key.Interface()
+}
+
+func newNamedIndex(def *indexDef) *namedIndex {
+	index := &namedIndex{def: def}
+	if def.multi {
+		index.multi = make(map[interface{}][]interface{})
+	} else {
+		index.unique = make(map[interface{}]interface{})
+	}
+	return index
+}
+
+// record 为 *st
+func (index *namedIndex) add(record interface{}) error {
+	v := reflect.ValueOf(record).Elem()
+	keys := make([]interface{}, len(index.def.fields))
+	for i, field := range index.def.fields {
+		keys[i] = v.Field(field).Interface()
+	}
+	key := indexKey(keys)
+
+	if index.multi != nil {
+		index.multi[key] = append(index.multi[key], record)
+		return nil
+	}
+	if _, ok := index.unique[key]; ok {
+		return fmt.Errorf("index %v error: duplicate key %v", index.def.name, keys)
+	}
+	index.unique[key] = record
+	return nil
+}
+
+func (rf *RecordFile) namedIndex(name string, keys []interface{}) (*namedIndex, interface{}) {
+	index := rf.load().named[name]
+	if index == nil || len(keys) != len(index.def.fields) {
+		return nil, nil
+	}
+	return index, indexKey(keys)
+}
+
+// Lookup returns the record of the unique index named index, keys are the
+// values of the index columns in field order, nil if not found
+func (rf *RecordFile) Lookup(index string, keys ...interface{}) interface{} {
+	i, key := rf.namedIndex(index, keys)
+	if i == nil || i.unique == nil {
+		return nil
+	}
+	return i.unique[key]
+}
+
+// LookupAll returns the records of the index named index (unique or not)
+func (rf *RecordFile) LookupAll(index string, keys ...interface{}) []interface{} {
+	i, key := rf.namedIndex(index, keys)
+	if i == nil {
+		return nil
+	}
+	if i.multi != nil {
+		return i.multi[key]
+	}
+	if r, ok := i.unique[key]; ok {
+		return []interface{}{r}
+	}
+	return nil
+}
+
+// Lookup is the typed version of RecordFile.Lookup, T is the record struct
+func Lookup[T any](rf *RecordFile, index string, keys ...interface{}) (*T, bool) {
+	r, ok := rf.Lookup(index, keys...).(*T)
+	return r, ok
+}
+
+// LookupAll is the typed version of RecordFile.LookupAll, T is the record struct
+func LookupAll[T any](rf *RecordFile, index string, keys ...interface{}) []*T {
+	rs := rf.LookupAll(index, keys...)
+	if len(rs) == 0 {
+		return nil
+	}
+
+	ts := make([]*T, 0, len(rs))
+	for _, r := range rs {
+		t, ok := r.(*T)
+		if !ok {
+			return nil
+		}
+		ts = append(ts, t)
+	}
+	return ts
+}
@@ -0,0 +1,59 @@
+package recordfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNamedIndex(t *testing.T) {
+	type Skill struct {
+		ID    int    `index:""`
+		Level int    `index:"lc;level,multi"`
+		Class string `index:"lc"`
+		Name  string
+	}
+
+	name := filepath.Join(t.TempDir(), "skill.txt")
+	content := "ID\tLevel\tClass\tName\n" +
+		"1\t1\twarrior\tslash\n" +
+		"2\t1\tmage\tfireball\n" +
+		"3\t2\twarrior\tcharge\n"
+	if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rf, err := New(Skill{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rf.Read(name); err != nil {
+		t.Fatal(err)
+	}
+
+	if r := rf.Index(2).(*Skill); r.Name != "fireball" {
+		t.Fatalf("unexpected record %v", r)
+	}
+
+	s, ok := Lookup[Skill](rf, "lc", 2, "warrior")
+	if !ok || s.Name != "charge" {
+		t.Fatalf("lc: unexpected record %v", s)
+	}
+	if _, ok := Lookup[Skill](rf, "lc", 2, "mage"); ok {
+		t.Fatal("lc: no record expected")
+	}
+
+	ss := LookupAll[Skill](rf, "level", 1)
+	if len(ss) != 2 || ss[0].Name != "slash" || ss[1].Name != "fireball" {
+		t.Fatalf("level: unexpected records %v", ss)
+	}
+
+	// duplicate composite key
+	content += "4\t1\tmage\tblizzard\n"
+	if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := rf.Reload(); err == nil {
+		t.Fatal("duplicate key expected")
+	}
+}
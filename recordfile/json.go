@@ -0,0 +1,51 @@
+package recordfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sort"
+)
+
+// parseJSON 将对象数组转换为表头加数据行, 字符串取其内容, null 视为空,
+// 其他值保留 JSON 文本 (数字, 布尔值, 数组和对象)
+func parseJSON(r io.Reader) (header []string, rows [][]string, err error) {
+	var objects []map[string]json.RawMessage
+	if err = json.NewDecoder(r).Decode(&objects); err != nil {
+		return
+	}
+
+	cols := make(map[string]int)
+	for _, object := range objects {
+		for key := range object {
+			if _, ok := cols[key]; !ok {
+				cols[key] = 0
+				header = append(header, key)
+			}
+		}
+	}
+	sort.Strings(header)
+	for i, key := range header {
+		cols[key] = i
+	}
+
+	rows = make([][]string, len(objects))
+	for n, object := range objects {
+		row := make([]string, len(header))
+		for key, raw := range object {
+			raw = bytes.TrimSpace(raw)
+			switch {
+			case bytes.Equal(raw, []byte("null")):
+			case len(raw) > 0 && raw[0] == '"':
+				if err = json.Unmarshal(raw, &row[cols[key]]); err != nil {
+					return
+				}
+			default:
+				row[cols[key]] = string(raw)
+			}
+		}
+		rows[n] = row
+	}
+
+	return
+}
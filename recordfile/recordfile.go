@@ -1,26 +1,61 @@
 package recordfile
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/csv"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"path"
 	"reflect"
-	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 var Comma = '\t'
 var Comment = '#'
 
+// source formats
+const (
+	FormatCSV  = "csv"  // 表头加数据行, 分隔符为 Comma
+	FormatJSON = "json" // 对象数组, 键为列名
+)
+
+// Excel 导出的 UTF-8 文件带 BOM
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
 type Index map[interface{}]interface{}
 
 type RecordFile struct {
 	Comma      rune
 	Comment    rune
+	Format     string // 为空时按文件扩展名判断, .json 为 JSON, 其余为 CSV
 	typeRecord reflect.Type
-	records    []interface{}
-	indexes    []Index
+	indexDefs  []*indexDef
+	fieldDefs  []*fieldDef
+	header     bool         // 按表头名绑定列
+	data       atomic.Value // *recordData, 重新加载时整体替换
+
+	mutexHandlers  sync.Mutex
+	reloadHandlers []func(old *RecordFile, new *RecordFile)
+}
+
+// 读取后不再修改
+type recordData struct {
+	records []interface{}
+	indexes []Index                // 单列唯一索引, 按字段顺序
+	named   map[string]*namedIndex // 索引名 -> 索引
+	source  *source                // 重新加载时的数据来源, 可为 nil
+}
+
+type source struct {
+	name   string
+	format string
+	open   func() (io.ReadCloser, error)
 }
 
 func New(st interface{}) (*RecordFile, error) {
@@ -56,163 +91,287 @@ func New(st interface{}) (*RecordFile, error) {
 			return nil, fmt.Errorf("invalid type: %v %s",
 				f.Name, kind)
 		}
+	}
 
-		tag := f.Tag
-		if tag == "index" {
-			switch kind {
-			case reflect.Struct, reflect.Slice, reflect.Map:
-				return nil, fmt.Errorf("could not index %s field %v %v",
-					kind, i, f.Name)
-			}
-		}
+	indexDefs, err := parseIndexDefs(typeRecord)
+	if err != nil {
+		return nil, err
+	}
+
+	fieldDefs, header, err := parseFieldDefs(typeRecord)
+	if err != nil {
+		return nil, err
 	}
 
 	rf := new(RecordFile)
 	rf.typeRecord = typeRecord
+	rf.indexDefs = indexDefs
+	rf.fieldDefs = fieldDefs
+	rf.header = header
+	registerReferrer(rf)
 
 	return rf, nil
 }
 
+// goroutine safe (after the first Read)
 func (rf *RecordFile) Read(name string) error {
-	file, err := os.Open(name)
+	err := rf.readSource(&source{
+		name:   name,
+		format: rf.format(name),
+		open: func() (io.ReadCloser, error) {
+			return os.Open(name)
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	register(files, name, rf)
+	return nil
+}
+
+// ReadFS reads the file name from fsys (e.g. an embed.FS),
+// ReloadFS(name) and NamesFS see it, Reload, Names and Watch do not
+// goroutine safe (after the first Read)
+func (rf *RecordFile) ReadFS(fsys fs.FS, name string) error {
+	err := rf.readSource(&source{
+		name:   name,
+		format: rf.format(name),
+		open: func() (io.ReadCloser, error) {
+			return fsys.Open(name)
+		},
+	})
 	if err != nil {
 		return err
 	}
+
+	register(fsFiles, name, rf)
+	return nil
+}
+
+// Parse reads the records from r in rf.Format (CSV by default),
+// the data can not be reloaded
+// goroutine safe (after the first Read)
+func (rf *RecordFile) Parse(r io.Reader) error {
+	d, err := rf.parse(r, rf.format(""))
+	if err != nil {
+		return err
+	}
+
+	rf.data.Store(d)
+	return nil
+}
+
+func (rf *RecordFile) format(name string) string {
+	if rf.Format != "" {
+		return rf.Format
+	}
+	if strings.EqualFold(path.Ext(name), ".json") {
+		return FormatJSON
+	}
+	return FormatCSV
+}
+
+func (rf *RecordFile) readSource(src *source) error {
+	d, err := rf.read(src)
+	if err != nil {
+		return err
+	}
+
+	rf.data.Store(d)
+	return nil
+}
+
+func (rf *RecordFile) read(src *source) (*recordData, error) {
+	file, err := src.open()
+	if err != nil {
+		return nil, err
+	}
 	defer file.Close()
 
-	if rf.Comma == 0 {
-		rf.Comma = Comma
+	d, err := rf.parse(file, src.format)
+	if err != nil {
+		return nil, err
+	}
+
+	d.source = src
+	return d, nil
+}
+
+func (rf *RecordFile) parse(r io.Reader, format string) (*recordData, error) {
+	reader := bufio.NewReader(r)
+	if b, err := reader.Peek(len(utf8BOM)); err == nil && bytes.Equal(b, utf8BOM) {
+		reader.Discard(len(utf8BOM))
+	}
+
+	switch format {
+	case FormatCSV:
+		return rf.parseCSV(reader)
+	case FormatJSON:
+		header, rows, err := parseJSON(reader)
+		if err != nil {
+			return nil, err
+		}
+		return rf.build(header, rows, true, true)
+	default:
+		return nil, fmt.Errorf("invalid format %v", format)
+	}
+}
+
+func (rf *RecordFile) parseCSV(r io.Reader) (*recordData, error) {
+	comma := rf.Comma
+	if comma == 0 {
+		comma = Comma
 	}
-	if rf.Comment == 0 {
-		rf.Comment = Comment
+	comment := rf.Comment
+	if comment == 0 {
+		comment = Comment
 	}
-	reader := csv.NewReader(file)
-	reader.Comma = rf.Comma
-	reader.Comment = rf.Comment
+	reader := csv.NewReader(r)
+	reader.Comma = comma
+	reader.Comment = comment
 	lines, err := reader.ReadAll()
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	if len(lines) == 0 {
+		return nil, errors.New("header line not found")
 	}
 
+	return rf.build(lines[0], lines[1:], rf.header, false)
+}
+
+// rows 的第 n 行即文件中的第 n+1 行数据 (row=n+1)
+// emptyZero 为 true 时没有默认值的空单元格取零值, 用于 JSON 中缺少的键和 null
+func (rf *RecordFile) build(header []string, rows [][]string, byName bool, emptyZero bool) (*recordData, error) {
 	typeRecord := rf.typeRecord
 
+	cols, err := rf.bindColumns(header, byName)
+	if err != nil {
+		return nil, err
+	}
+
 	// make records
-	records := make([]interface{}, len(lines)-1)
+	records := make([]interface{}, len(rows))
 
 	// make indexes
 	indexes := []Index{}
-	for i := 0; i < typeRecord.NumField(); i++ {
-		tag := typeRecord.Field(i).Tag
-		if tag == "index" {
-			indexes = append(indexes, make(Index))
+	named := make(map[string]*namedIndex)
+	for _, def := range rf.indexDefs {
+		index := newNamedIndex(def)
+		named[def.name] = index
+		if index.unique != nil && len(def.fields) == 1 {
+			indexes = append(indexes, index.unique)
 		}
 	}
 
-	for n := 1; n < len(lines); n++ {
+	for n := 1; n <= len(rows); n++ {
 		value := reflect.New(typeRecord)
 		records[n-1] = value.Interface()
 		record := value.Elem()
 
-		line := lines[n]
-		if len(line) != typeRecord.NumField() {
-			return fmt.Errorf("line %v, field count mismatch: %v (file) %v (st)",
+		line := rows[n-1]
+		if !byName && len(line) < typeRecord.NumField() {
+			return nil, fmt.Errorf("line %v, field count mismatch: %v (file) %v (st)",
 				n, len(line), typeRecord.NumField())
 		}
 
-		iIndex := 0
-
 		for i := 0; i < typeRecord.NumField(); i++ {
-			f := typeRecord.Field(i)
-
 			// records
-			strField := line[i]
 			field := record.Field(i)
-			if !field.CanSet() {
+			if !field.CanSet() || cols[i] < 0 {
 				continue
 			}
 
-			var err error
+			fd := rf.fieldDefs[i]
+			strField := line[cols[i]]
+			if strField == "" && fd.hasDefault {
+				strField = fd.defaultStr
+			}
 
-			kind := f.Type.Kind()
-			if kind == reflect.Bool {
-				var v bool
-				v, err = strconv.ParseBool(strField)
-				if err == nil {
-					field.SetBool(v)
-				}
-			} else if kind == reflect.Int ||
-				kind == reflect.Int8 ||
-				kind == reflect.Int16 ||
-				kind == reflect.Int32 ||
-				kind == reflect.Int64 {
-				var v int64
-				v, err = strconv.ParseInt(strField, 0, f.Type.Bits())
-				if err == nil {
-					field.SetInt(v)
-				}
-			} else if kind == reflect.Uint ||
-				kind == reflect.Uint8 ||
-				kind == reflect.Uint16 ||
-				kind == reflect.Uint32 ||
-				kind == reflect.Uint64 {
-				var v uint64
-				v, err = strconv.ParseUint(strField, 0, f.Type.Bits())
-				if err == nil {
-					field.SetUint(v)
+			if strField != "" || !emptyZero {
+				if err := setField(field, strField); err != nil {
+					return nil, fmt.Errorf("parse field %v (row=%v, col=%v) error: %v",
+						fd.name, n, cols[i], err)
 				}
-			} else if kind == reflect.Float32 ||
-				kind == reflect.Float64 {
-				var v float64
-				v, err = strconv.ParseFloat(strField, f.Type.Bits())
-				if err == nil {
-					field.SetFloat(v)
-				}
-			} else if kind == reflect.String {
-				field.SetString(strField)
-			} else if kind == reflect.Struct ||
-				kind == reflect.Array ||
-				kind == reflect.Slice ||
-				kind == reflect.Map {
-				err = json.Unmarshal([]byte(strField), field.Addr().Interface())
 			}
+			if err := fd.validate(field); err != nil {
+				return nil, fmt.Errorf("validate field %v (row=%v, col=%v) error: %v",
+					fd.name, n, cols[i], err)
+			}
+		}
 
-			if err != nil {
-				return fmt.Errorf("parse field (row=%v, col=%v) error: %v",
-					n, i, err)
+		// 文件中没有的列取默认值
+		for i, fd := range rf.fieldDefs {
+			field := record.Field(i)
+			if !field.CanSet() || cols[i] >= 0 || !fd.hasDefault {
+				continue
 			}
+			if err := setField(field, fd.defaultStr); err != nil {
+				return nil, fmt.Errorf("parse default of field %v error: %v", fd.name, err)
+			}
+			if err := fd.validate(field); err != nil {
+				return nil, fmt.Errorf("validate field %v (row=%v) error: %v", fd.name, n, err)
+			}
+		}
 
-			// indexes
-			if f.Tag == "index" {
-				index := indexes[iIndex]
-				iIndex++
-				if _, ok := index[field.Interface()]; ok {
-					return fmt.Errorf("index error: duplicate at (row=%v, col=%v)",
-						n, i)
+		// indexes
+		for _, def := range rf.indexDefs {
+			if err := named[def.name].add(records[n-1]); err != nil {
+				// 报告文件中的列, 文件中没有的列 (取默认值) 报告字段名
+				if col := cols[def.fields[0]]; col >= 0 {
+					return nil, fmt.Errorf("%v at (row=%v, col=%v)", err, n, col)
 				}
-				index[field.Interface()] = records[n-1]
+				return nil, fmt.Errorf("%v at (row=%v, field %v)", err, n, rf.fieldDefs[def.fields[0]].name)
 			}
 		}
 	}
 
-	rf.records = records
-	rf.indexes = indexes
+	return &recordData{records: records, indexes: indexes, named: named}, nil
+}
 
-	return nil
+func (rf *RecordFile) load() *recordData {
+	d, _ := rf.data.Load().(*recordData)
+	if d == nil {
+		return new(recordData)
+	}
+	return d
+}
+
+// 固定当前数据的副本
+func (rf *RecordFile) snapshot(d *recordData) *RecordFile {
+	s := new(RecordFile)
+	s.Comma = rf.Comma
+	s.Comment = rf.Comment
+	s.typeRecord = rf.typeRecord
+	s.indexDefs = rf.indexDefs
+	s.fieldDefs = rf.fieldDefs
+	s.header = rf.header
+	s.data.Store(d)
+	return s
 }
 
 func (rf *RecordFile) Record(i int) interface{} {
-	return rf.records[i]
+	return rf.load().records[i]
 }
 
 func (rf *RecordFile) NumRecord() int {
-	return len(rf.records)
+	return len(rf.load().records)
+}
+
+// Records returns all records of the current data, the result stays
+// consistent even if the file is reloaded meanwhile
+func (rf *RecordFile) Records() []interface{} {
+	return rf.load().records
 }
 
 func (rf *RecordFile) Indexes(i int) Index {
-	if i >= len(rf.indexes) {
+	d := rf.load()
+	if i >= len(d.indexes) {
 		return nil
 	}
-	return rf.indexes[i]
+	return d.indexes[i]
 }
 
 func (rf *RecordFile) Index(i interface{}) interface{} {
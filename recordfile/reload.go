@@ -0,0 +1,216 @@
+package recordfile
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jiangzuomin/leaf/log"
+	"github.com/sirupsen/logrus"
+)
+
+// file name -> record files read from it, files on disk (Read) and files in
+// an fs.FS (ReadFS) are kept apart so that files of the same name do not mix
+var (
+	files      = make(map[string][]*RecordFile)
+	fsFiles    = make(map[string][]*RecordFile)
+	mutexFiles sync.Mutex
+)
+
+func register(registry map[string][]*RecordFile, name string, rf *RecordFile) {
+	mutexFiles.Lock()
+	defer mutexFiles.Unlock()
+
+	for _, f := range registry[name] {
+		if f == rf {
+			return
+		}
+	}
+	registry[name] = append(registry[name], rf)
+}
+
+// OnReload adds a handler called after each successful Reload with snapshots
+// of the data before and after the reload. The handler runs on the reloading
+// goroutine, use module.Skeleton.Post to run it on a module goroutine.
+// goroutine safe
+func (rf *RecordFile) OnReload(f func(old *RecordFile, new *RecordFile)) {
+	rf.mutexHandlers.Lock()
+	rf.reloadHandlers = append(rf.reloadHandlers, f)
+	rf.mutexHandlers.Unlock()
+}
+
+// Reload re-reads the file last passed to Read or ReadFS. The whole file is
+// parsed and validated first, the data is swapped only on success, so readers
+// always see either the old or the new table.
+// goroutine safe
+func (rf *RecordFile) Reload() error {
+	return reload([]*RecordFile{rf})
+}
+
+// 先读取并校验所有文件 (包括引用它们的表), 全部成功后再替换, 不会只更新其中一部分
+func reload(rfs []*RecordFile) error {
+	olds := make([]*recordData, len(rfs))
+	news := make([]*recordData, len(rfs))
+	for i, rf := range rfs {
+		old := rf.load()
+		if old.source == nil {
+			return errors.New("record file not read from a file")
+		}
+
+		d, err := rf.read(old.source)
+		if err != nil {
+			return err
+		}
+		olds[i], news[i] = old, d
+	}
+
+	pending := make(map[*RecordFile]*recordData)
+	for i, rf := range rfs {
+		pending[rf] = news[i]
+	}
+	if err := checkReferrers(pending); err != nil {
+		return err
+	}
+
+	for i, rf := range rfs {
+		rf.data.Store(news[i])
+	}
+
+	for i, rf := range rfs {
+		rf.notify(olds[i], news[i])
+	}
+	return nil
+}
+
+func (rf *RecordFile) notify(old *recordData, new *recordData) {
+	rf.mutexHandlers.Lock()
+	handlers := append([]func(*RecordFile, *RecordFile){}, rf.reloadHandlers...)
+	rf.mutexHandlers.Unlock()
+
+	if len(handlers) == 0 {
+		return
+	}
+	oldRf, newRf := rf.snapshot(old), rf.snapshot(new)
+	for _, f := range handlers {
+		f(oldRf, newRf)
+	}
+}
+
+// Reload reloads every record file read from name by Read, either all of them
+// are swapped or none
+// goroutine safe
+func Reload(name string) error {
+	return reloadFiles(files, name)
+}
+
+// ReloadFS is Reload for the files read from name by ReadFS
+// goroutine safe
+func ReloadFS(name string) error {
+	return reloadFiles(fsFiles, name)
+}
+
+func reloadFiles(registry map[string][]*RecordFile, name string) error {
+	mutexFiles.Lock()
+	rfs := append([]*RecordFile{}, registry[name]...)
+	mutexFiles.Unlock()
+
+	if len(rfs) == 0 {
+		return errors.New("record file " + name + " not read")
+	}
+
+	return reload(rfs)
+}
+
+// Names returns the names of all files read by Read so far
+// goroutine safe
+func Names() []string {
+	return names(files)
+}
+
+// NamesFS returns the names of all files read by ReadFS so far
+// goroutine safe
+func NamesFS() []string {
+	return names(fsFiles)
+}
+
+func names(registry map[string][]*RecordFile) []string {
+	mutexFiles.Lock()
+	defer mutexFiles.Unlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	return names
+}
+
+var (
+	closeWatch chan bool
+	mutexWatch sync.Mutex
+)
+
+// Watch polls the files read so far every interval and reloads those whose
+// modification time changed, failed reloads are logged and keep the old data.
+// Only files read by Read are polled, files read by ReadFS need ReloadFS.
+// goroutine safe
+func Watch(interval time.Duration) {
+	mutexWatch.Lock()
+	defer mutexWatch.Unlock()
+
+	if closeWatch != nil {
+		return
+	}
+	closeWatch = make(chan bool)
+
+	go watch(interval, closeWatch)
+}
+
+// goroutine safe
+func StopWatch() {
+	mutexWatch.Lock()
+	defer mutexWatch.Unlock()
+
+	if closeWatch != nil {
+		close(closeWatch)
+		closeWatch = nil
+	}
+}
+
+func watch(interval time.Duration, closeSig chan bool) {
+	modTimes := make(map[string]time.Time)
+	for _, name := range Names() {
+		if fi, err := os.Stat(name); err == nil {
+			modTimes[name] = fi.ModTime()
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-closeSig:
+			return
+		case <-ticker.C:
+		}
+
+		for _, name := range Names() {
+			fi, err := os.Stat(name)
+			if err != nil {
+				continue
+			}
+			modTime, ok := modTimes[name]
+			modTimes[name] = fi.ModTime()
+			if !ok || modTime.Equal(fi.ModTime()) {
+				continue
+			}
+
+			if err := Reload(name); err != nil {
+				log.Log.WithFields(logrus.Fields{"file": name, "err": err}).Error("reload record file")
+			} else {
+				log.Log.WithField("file", name).Info("record file reloaded")
+			}
+		}
+	}
+}
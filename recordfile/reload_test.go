@@ -0,0 +1,98 @@
+package recordfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReload(t *testing.T) {
+	type Drop struct {
+		ID   int `index:""`
+		Rate float64
+	}
+
+	name := filepath.Join(t.TempDir(), "drop.txt")
+	write := func(content string) {
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("ID\tRate\n1\t0.5\n")
+	rf, err := New(Drop{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rf.Read(name); err != nil {
+		t.Fatal(err)
+	}
+
+	var oldRate, newRate float64
+	rf.OnReload(func(old *RecordFile, new *RecordFile) {
+		oldRate = old.Index(1).(*Drop).Rate
+		newRate = new.Index(1).(*Drop).Rate
+	})
+
+	// invalid data keeps the old table
+	write("ID\tRate\n1\tbad\n")
+	if err := Reload(name); err == nil {
+		t.Fatal("reload of invalid file should fail")
+	}
+	if rf.Index(1).(*Drop).Rate != 0.5 {
+		t.Fatal("old table should be kept")
+	}
+
+	write("ID\tRate\n1\t0.8\n2\t0.1\n")
+	if err := Reload(name); err != nil {
+		t.Fatal(err)
+	}
+	if rf.NumRecord() != 2 || rf.Index(1).(*Drop).Rate != 0.8 {
+		t.Fatal("new table expected")
+	}
+	if oldRate != 0.5 || newRate != 0.8 {
+		t.Fatalf("unexpected handler arguments %v %v", oldRate, newRate)
+	}
+}
+
+func TestReloadAll(t *testing.T) {
+	type Drop struct {
+		ID   int `index:""`
+		Rate float64
+	}
+	type DropCheck struct {
+		ID   int     `col:"ID"`
+		Rate float64 `validate:"range=0..1"`
+	}
+
+	name := filepath.Join(t.TempDir(), "drop.txt")
+	write := func(content string) {
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("ID\tRate\n1\t0.5\n")
+	drop, err := New(Drop{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check, err := New(DropCheck{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rf := range []*RecordFile{drop, check} {
+		if err := rf.Read(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// valid for drop but not for check, neither is swapped
+	write("ID\tRate\n1\t2\n")
+	if err := Reload(name); err == nil {
+		t.Fatal("reload should fail")
+	}
+	if drop.Index(1).(*Drop).Rate != 0.5 {
+		t.Fatal("drop swapped although check failed")
+	}
+}
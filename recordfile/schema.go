@@ -0,0 +1,378 @@
+package recordfile
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Schema tags:
+//
+//	Name  string `col:"名字" validate:"nonempty"` // bind to the column with header 名字
+//	Level int    `default:"1" validate:"range=1..100"`
+//	Class string `validate:"enum=warrior|mage"`
+//	Item  int    `validate:"ref=item"`      // foreign key into the first index of table "item"
+//	Skill int    `validate:"ref=skill.lc"`  // foreign key into index "lc" of table "skill"
+//	Temp  int    `col:"-"`                  // not read from the file
+//
+// Columns are bound by header name as soon as one field has a col tag, fields
+// without it use their field name, extra columns are ignored. Otherwise columns
+// are bound by position and columns after the last field are ignored. A column
+// that is missing or an empty cell takes the default value if any.
+type fieldDef struct {
+	name       string // column name
+	skip       bool
+	hasDefault bool
+	defaultStr string
+	rules      []rule
+}
+
+type rule struct {
+	name string
+	arg  string
+}
+
+func parseFieldDefs(typeRecord reflect.Type) (fields []*fieldDef, header bool, err error) {
+	for i := 0; i < typeRecord.NumField(); i++ {
+		f := typeRecord.Field(i)
+
+		fd := &fieldDef{name: f.Name}
+		if col, ok := f.Tag.Lookup("col"); ok {
+			header = true
+			if col == "-" {
+				fd.skip = true
+			} else if col != "" {
+				fd.name = col
+			}
+		}
+		fd.defaultStr, fd.hasDefault = f.Tag.Lookup("default")
+
+		if validate, ok := f.Tag.Lookup("validate"); ok {
+			for _, entry := range strings.Split(validate, ";") {
+				entry = strings.TrimSpace(entry)
+				if entry == "" {
+					continue
+				}
+				r := rule{name: entry}
+				if i := strings.Index(entry, "="); i >= 0 {
+					r.name, r.arg = entry[:i], entry[i+1:]
+				}
+				if err = checkRule(f, r); err != nil {
+					return nil, false, fmt.Errorf("field %v: %v", f.Name, err)
+				}
+				fd.rules = append(fd.rules, r)
+			}
+		}
+
+		fields = append(fields, fd)
+	}
+
+	return
+}
+
+func checkRule(f reflect.StructField, r rule) error {
+	switch r.name {
+	case "nonempty":
+	case "range":
+		switch f.Type.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+		default:
+			return fmt.Errorf("range on %s field", f.Type.Kind())
+		}
+		if _, _, err := parseRange(r.arg); err != nil {
+			return err
+		}
+	case "enum":
+		if r.arg == "" {
+			return fmt.Errorf("empty enum")
+		}
+	case "ref":
+		if r.arg == "" {
+			return fmt.Errorf("empty ref")
+		}
+	default:
+		return fmt.Errorf("unknown validate rule %v", r.name)
+	}
+	return nil
+}
+
+// min..max, either side may be omitted
+func parseRange(arg string) (min *float64, max *float64, err error) {
+	bounds := strings.Split(arg, "..")
+	if len(bounds) != 2 {
+		return nil, nil, fmt.Errorf("invalid range %v", arg)
+	}
+	if bounds[0] != "" {
+		v, e := strconv.ParseFloat(bounds[0], 64)
+		if e != nil {
+			return nil, nil, fmt.Errorf("invalid range %v", arg)
+		}
+		min = &v
+	}
+	if bounds[1] != "" {
+		v, e := strconv.ParseFloat(bounds[1], 64)
+		if e != nil {
+			return nil, nil, fmt.Errorf("invalid range %v", arg)
+		}
+		max = &v
+	}
+	return
+}
+
+// bindColumns returns the column of each field, -1 means not read from the file
+func (rf *RecordFile) bindColumns(header []string, byName bool) ([]int, error) {
+	cols := make([]int, len(rf.fieldDefs))
+	if !byName {
+		for i := range cols {
+			cols[i] = i
+		}
+		return cols, nil
+	}
+
+	colByName := make(map[string]int)
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		if _, ok := colByName[name]; !ok {
+			colByName[name] = i
+		}
+	}
+
+	for i, fd := range rf.fieldDefs {
+		cols[i] = -1
+		if fd.skip || rf.typeRecord.Field(i).PkgPath != "" {
+			continue
+		}
+		col, ok := colByName[fd.name]
+		if ok {
+			cols[i] = col
+		} else if !fd.hasDefault {
+			return nil, fmt.Errorf("column %v not found", fd.name)
+		}
+	}
+	return cols, nil
+}
+
+func setField(field reflect.Value, strField string) (err error) {
+	kind := field.Kind()
+	if kind == reflect.Bool {
+		var v bool
+		v, err = strconv.ParseBool(strField)
+		if err == nil {
+			field.SetBool(v)
+		}
+	} else if kind == reflect.Int ||
+		kind == reflect.Int8 ||
+		kind == reflect.Int16 ||
+		kind == reflect.Int32 ||
+		kind == reflect.Int64 {
+		var v int64
+		v, err = strconv.ParseInt(strField, 0, field.Type().Bits())
+		if err == nil {
+			field.SetInt(v)
+		}
+	} else if kind == reflect.Uint ||
+		kind == reflect.Uint8 ||
+		kind == reflect.Uint16 ||
+		kind == reflect.Uint32 ||
+		kind == reflect.Uint64 {
+		var v uint64
+		v, err = strconv.ParseUint(strField, 0, field.Type().Bits())
+		if err == nil {
+			field.SetUint(v)
+		}
+	} else if kind == reflect.Float32 ||
+		kind == reflect.Float64 {
+		var v float64
+		v, err = strconv.ParseFloat(strField, field.Type().Bits())
+		if err == nil {
+			field.SetFloat(v)
+		}
+	} else if kind == reflect.String {
+		field.SetString(strField)
+	} else if kind == reflect.Struct ||
+		kind == reflect.Array ||
+		kind == reflect.Slice ||
+		kind == reflect.Map {
+		err = json.Unmarshal([]byte(strField), field.Addr().Interface())
+	}
+	return
+}
+
+func (fd *fieldDef) validate(field reflect.Value) error {
+	for _, r := range fd.rules {
+		var err error
+		switch r.name {
+		case "nonempty":
+			err = validateNonEmpty(field)
+		case "range":
+			err = validateRange(field, r.arg)
+		case "enum":
+			err = validateEnum(field, r.arg)
+		case "ref":
+			err = validateRef(field, r.arg)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateNonEmpty(field reflect.Value) error {
+	switch field.Kind() {
+	case reflect.String, reflect.Array, reflect.Slice, reflect.Map:
+		if field.Len() == 0 {
+			return fmt.Errorf("empty value")
+		}
+	default:
+		if field.IsZero() {
+			return fmt.Errorf("empty value")
+		}
+	}
+	return nil
+}
+
+func validateRange(field reflect.Value, arg string) error {
+	var v float64
+	switch field.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v = float64(field.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v = float64(field.Uint())
+	default:
+		v = field.Float()
+	}
+
+	min, max, _ := parseRange(arg)
+	if (min != nil && v < *min) || (max != nil && v > *max) {
+		return fmt.Errorf("%v out of range %v", field.Interface(), arg)
+	}
+	return nil
+}
+
+func validateEnum(field reflect.Value, arg string) error {
+	v := fmt.Sprint(field.Interface())
+	for _, e := range strings.Split(arg, "|") {
+		if v == e {
+			return nil
+		}
+	}
+	return fmt.Errorf("%v not in enum %v", v, arg)
+}
+
+func validateRef(field reflect.Value, arg string) error {
+	return checkRef(field, arg, nil)
+}
+
+func splitRef(arg string) (table string, index string) {
+	table = arg
+	if i := strings.Index(arg, "."); i >= 0 {
+		table, index = arg[:i], arg[i+1:]
+	}
+	return
+}
+
+// pending 为重新加载中尚未替换的数据, 其中的表按新数据校验
+func checkRef(field reflect.Value, arg string, pending map[*RecordFile]*recordData) error {
+	table, index := splitRef(arg)
+
+	ref := getTable(table)
+	if ref == nil {
+		return fmt.Errorf("table %v not registered", table)
+	}
+	if d, ok := pending[ref]; ok {
+		ref = ref.snapshot(d)
+	}
+
+	var r interface{}
+	if index == "" {
+		r = ref.Index(field.Interface())
+	} else {
+		r = ref.Lookup(index, field.Interface())
+	}
+	if r == nil {
+		return fmt.Errorf("%v not found in %v", field.Interface(), arg)
+	}
+	return nil
+}
+
+// table name -> record file, for foreign keys
+var (
+	tables      = make(map[string]*RecordFile)
+	referrers   []*RecordFile // 有 ref 规则的 record file, 被引用的表重新加载时重新校验
+	mutexTables sync.RWMutex
+)
+
+// RegisterTable names rf so that it can be referenced by `validate:"ref=name"`,
+// the referenced table must be read before the tables referencing it. A reload
+// of rf fails if a table already read would reference a missing record.
+// goroutine safe
+func RegisterTable(name string, rf *RecordFile) {
+	mutexTables.Lock()
+	tables[name] = rf
+	mutexTables.Unlock()
+}
+
+func getTable(name string) *RecordFile {
+	mutexTables.RLock()
+	defer mutexTables.RUnlock()
+	return tables[name]
+}
+
+func registerReferrer(rf *RecordFile) {
+	for _, fd := range rf.fieldDefs {
+		for _, r := range fd.rules {
+			if r.name == "ref" {
+				mutexTables.Lock()
+				referrers = append(referrers, rf)
+				mutexTables.Unlock()
+				return
+			}
+		}
+	}
+}
+
+// checkReferrers checks the references into the tables of pending against
+// their new data, before the data is swapped
+func checkReferrers(pending map[*RecordFile]*recordData) error {
+	mutexTables.RLock()
+	rfs := append([]*RecordFile{}, referrers...)
+	mutexTables.RUnlock()
+
+	for _, rf := range rfs {
+		d, ok := pending[rf]
+		if !ok {
+			d = rf.load()
+		}
+
+		for i, fd := range rf.fieldDefs {
+			for _, r := range fd.rules {
+				if r.name != "ref" {
+					continue
+				}
+				table, _ := splitRef(r.arg)
+				if ref := getTable(table); ref == nil || pending[ref] == nil {
+					continue
+				}
+
+				for n, record := range d.records {
+					field := reflect.ValueOf(record).Elem().Field(i)
+					if err := checkRef(field, r.arg, pending); err != nil {
+						name := "record file"
+						if d.source != nil {
+							name = d.source.name
+						}
+						return fmt.Errorf("%v: validate field %v (row=%v) error: %v",
+							name, fd.name, n+1, err)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
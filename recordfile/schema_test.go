@@ -0,0 +1,178 @@
+package recordfile
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSchema(t *testing.T) {
+	type Item struct {
+		ID int `index:""`
+	}
+	type Drop struct {
+		Name  string  `col:"名字" validate:"nonempty"`
+		Level int     `default:"1" validate:"range=1..100"`
+		Class string  `validate:"enum=warrior|mage"`
+		Item  int     `validate:"ref=item"`
+		Rate  float64 `default:"0.5"`
+		Temp  int     `col:"-"`
+	}
+
+	dir := t.TempDir()
+	write := func(name string, content string) string {
+		name = filepath.Join(dir, name)
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return name
+	}
+
+	item, err := New(Item{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := item.Read(write("item.txt", "ID\n10\n20\n")); err != nil {
+		t.Fatal(err)
+	}
+	RegisterTable("item", item)
+
+	drop, err := New(Drop{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// columns in any order, extra column, missing optional column
+	name := write("drop.txt", "Class\tExtra\t名字\tItem\tLevel\n"+
+		"mage\tx\tboss\t20\t\n")
+	if err := drop.Read(name); err != nil {
+		t.Fatal(err)
+	}
+	r := drop.Record(0).(*Drop)
+	if r.Name != "boss" || r.Level != 1 || r.Class != "mage" || r.Item != 20 || r.Rate != 0.5 {
+		t.Fatalf("unexpected record %+v", r)
+	}
+
+	invalid := map[string]string{
+		"nonempty": "名字\tClass\tItem\n\tmage\t10\n",
+		"range":    "名字\tClass\tItem\tLevel\nboss\tmage\t10\t101\n",
+		"enum":     "名字\tClass\tItem\nboss\tthief\t10\n",
+		"ref":      "名字\tClass\tItem\nboss\tmage\t30\n",
+		"column":   "名字\tItem\nboss\t10\n",
+	}
+	for rule, content := range invalid {
+		err := drop.Read(write("drop.txt", content))
+		if err == nil {
+			t.Fatalf("%v: error expected", rule)
+		}
+		if rule != "column" && !strings.Contains(err.Error(), "row=1") {
+			t.Fatalf("%v: position expected in %v", rule, err)
+		}
+	}
+}
+
+func TestIndexErrorColumn(t *testing.T) {
+	type Item struct {
+		Name string `col:"Name"` // col tags bind the columns by header
+		ID   int    `col:"ID" index:""`
+	}
+
+	name := filepath.Join(t.TempDir(), "item.txt")
+	// ID is the first column of the file but the second field
+	if err := os.WriteFile(name, []byte("ID\tName\n1\ta\n1\tb\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rf, err := New(Item{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rf.Read(name)
+	if err == nil || !strings.Contains(err.Error(), "(row=2, col=0)") {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestExtraColumns(t *testing.T) {
+	type Item struct {
+		ID   int `index:""`
+		Name string
+	}
+
+	name := filepath.Join(t.TempDir(), "item.txt")
+	// 新增的列在最后, 旧的服务器仍可读取
+	if err := os.WriteFile(name, []byte("ID\tName\tPrice\n1\tknife\t10\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rf, err := New(Item{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rf.Read(name); err != nil {
+		t.Fatal(err)
+	}
+	if r := rf.Index(1).(*Item); r.Name != "knife" {
+		t.Fatalf("unexpected record %+v", r)
+	}
+
+	if err := os.WriteFile(name, []byte("ID\n1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := rf.Read(name); err == nil {
+		t.Fatal("missing column expected")
+	}
+}
+
+func TestReloadRef(t *testing.T) {
+	type Item struct {
+		ID int `index:""`
+	}
+	type Drop struct {
+		Item int `validate:"ref=reload_item"`
+	}
+
+	dir := t.TempDir()
+	write := func(name string, content string) string {
+		name = filepath.Join(dir, name)
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return name
+	}
+
+	item, err := New(Item{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	itemName := write("item.txt", "ID\n10\n20\n")
+	if err := item.Read(itemName); err != nil {
+		t.Fatal(err)
+	}
+	RegisterTable("reload_item", item)
+
+	drop, err := New(Drop{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := drop.Read(write("drop.txt", "Item\n20\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	// item 20 is still referenced by drop
+	write("item.txt", "ID\n10\n")
+	err = Reload(itemName)
+	if err == nil || !strings.Contains(err.Error(), "row=1") {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if item.Index(20) == nil {
+		t.Fatal("old item table should be kept")
+	}
+
+	write("item.txt", "ID\n20\n30\n")
+	if err := Reload(itemName); err != nil {
+		t.Fatal(err)
+	}
+	if item.Index(30) == nil {
+		t.Fatal("new item table expected")
+	}
+}
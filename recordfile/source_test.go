@@ -0,0 +1,106 @@
+package recordfile
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+type sourceRecord struct {
+	ID    int    `index:""`
+	Name  string `col:"名字"`
+	Level int    `default:"1"`
+	Arr   []int
+}
+
+func checkSourceRecords(t *testing.T, rf *RecordFile) {
+	r := rf.Index(2).(*sourceRecord)
+	if rf.NumRecord() != 2 || r.Name != "cat" || r.Level != 1 || len(r.Arr) != 2 || r.Arr[1] != 4 {
+		t.Fatalf("unexpected record %+v", r)
+	}
+}
+
+func TestReadFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"drop.json": {Data: []byte(`[
+			{"ID": 1, "名字": "knife", "Level": 3, "Arr": [1, 2]},
+			{"ID": 2, "名字": "cat", "Level": null, "Arr": [3, 4]}
+		]`)},
+		"drop.txt": {Data: []byte("\xEF\xBB\xBFID\t名字\tArr\n" +
+			"1\tknife\t[1, 2]\n" +
+			"2\tcat\t[3, 4]\n")},
+	}
+
+	for _, name := range []string{"drop.json", "drop.txt"} {
+		rf, err := New(sourceRecord{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := rf.ReadFS(fsys, name); err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		checkSourceRecords(t, rf)
+		if err := rf.Reload(); err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	rf, err := New(sourceRecord{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rf.Format = FormatJSON
+
+	err = rf.Parse(strings.NewReader(`[{"ID": 1, "名字": "knife"}, {"ID": 2, "名字": "cat", "Arr": [3, 4]}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSourceRecords(t, rf)
+	if err := rf.Reload(); err == nil {
+		t.Fatal("parsed data should not be reloadable")
+	}
+}
+
+func TestReloadFS(t *testing.T) {
+	name := "reload_fs.txt"
+	fsys := fstest.MapFS{
+		name: {Data: []byte("ID\t名字\tArr\n1\tknife\t[]\n")},
+	}
+
+	rf, err := New(sourceRecord{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rf.ReadFS(fsys, name); err != nil {
+		t.Fatal(err)
+	}
+
+	found := false
+	for _, n := range NamesFS() {
+		if n == name {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("%v not in NamesFS()", name)
+	}
+	// 不是磁盘上的文件
+	for _, n := range Names() {
+		if n == name {
+			t.Fatalf("%v in Names()", name)
+		}
+	}
+	if err := Reload(name); err == nil {
+		t.Fatal("Reload should not see files read by ReadFS")
+	}
+
+	fsys[name] = &fstest.MapFile{Data: []byte("ID\t名字\tArr\n1\tknife\t[]\n2\tcat\t[3, 4]\n")}
+	if err := ReloadFS(name); err != nil {
+		t.Fatal(err)
+	}
+	if rf.NumRecord() != 2 || rf.Index(2).(*sourceRecord).Name != "cat" {
+		t.Fatal("reload did not pick up the new data")
+	}
+}
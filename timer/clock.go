@@ -0,0 +1,140 @@
+package timer
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// Clock is the time source of a Dispatcher, AfterFunc calls f on its own
+// goroutine like time.AfterFunc
+// goroutine safe
+type Clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) Stopper
+}
+
+type Stopper interface {
+	Stop() bool
+}
+
+// SystemClock uses time.Now and one runtime timer per AfterFunc
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Stopper {
+	return time.AfterFunc(d, f)
+}
+
+// FakeClock only moves when told to, timers fire on the goroutine calling
+// Advance or Set, so a Dispatcher using it needs a buffered ChanTimer
+// goroutine safe
+type FakeClock struct {
+	sync.Mutex
+	now    time.Time
+	seq    int
+	timers fakeTimers
+}
+
+type fakeTimer struct {
+	clock *FakeClock
+	when  time.Time
+	seq   int // 相同时间按添加顺序触发
+	f     func()
+	index int // 在堆中的位置, -1 为已移除
+}
+
+type fakeTimers []*fakeTimer
+
+func (ts fakeTimers) Len() int { return len(ts) }
+func (ts fakeTimers) Less(i, j int) bool {
+	if ts[i].when.Equal(ts[j].when) {
+		return ts[i].seq < ts[j].seq
+	}
+	return ts[i].when.Before(ts[j].when)
+}
+func (ts fakeTimers) Swap(i, j int) {
+	ts[i], ts[j] = ts[j], ts[i]
+	ts[i].index = i
+	ts[j].index = j
+}
+func (ts *fakeTimers) Push(x interface{}) {
+	t := x.(*fakeTimer)
+	t.index = len(*ts)
+	*ts = append(*ts, t)
+}
+func (ts *fakeTimers) Pop() interface{} {
+	old := *ts
+	t := old[len(old)-1]
+	old[len(old)-1] = nil
+	t.index = -1
+	*ts = old[:len(old)-1]
+	return t
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	c := new(FakeClock)
+	c.now = now
+	return c
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) Stopper {
+	c.Lock()
+	defer c.Unlock()
+
+	c.seq++
+	t := &fakeTimer{clock: c, when: c.now.Add(d), seq: c.seq, f: f}
+	heap.Push(&c.timers, t)
+	return t
+}
+
+func (t *fakeTimer) Stop() bool {
+	c := t.clock
+	c.Lock()
+	defer c.Unlock()
+
+	if t.index < 0 {
+		return false
+	}
+	heap.Remove(&c.timers, t.index)
+	return true
+}
+
+// Advance moves the clock forward by d, firing due timers in order
+func (c *FakeClock) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
+
+// Set moves the clock to now, firing due timers in order,
+// the clock never goes backwards
+func (c *FakeClock) Set(now time.Time) {
+	for {
+		c.Lock()
+		if len(c.timers) == 0 || c.timers[0].when.After(now) {
+			if now.After(c.now) {
+				c.now = now
+			}
+			c.Unlock()
+			return
+		}
+
+		t := heap.Pop(&c.timers).(*fakeTimer)
+		if t.when.After(c.now) {
+			c.now = t.when
+		}
+		c.Unlock()
+
+		t.f()
+	}
+}
@@ -9,14 +9,31 @@ import (
 	"time"
 )
 
-// Field name   | Mandatory? | Allowed values | Allowed special characters
-// ----------   | ---------- | -------------- | --------------------------
-// Seconds      | No         | 0-59           | * / , -
-// Minutes      | Yes        | 0-59           | * / , -
-// Hours        | Yes        | 0-23           | * / , -
-// Day of month | Yes        | 1-31           | * / , -
-// Month        | Yes        | 1-12           | * / , -
-// Day of week  | Yes        | 0-6            | * / , -
+// Field name   | Mandatory? | Allowed values  | Allowed special characters
+// ----------   | ---------- | --------------  | --------------------------
+// Seconds      | No         | 0-59            | * / , -
+// Minutes      | Yes        | 0-59            | * / , -
+// Hours        | Yes        | 0-23            | * / , -
+// Day of month | Yes        | 1-31            | * / , - ? L W
+// Month        | Yes        | 1-12 or JAN-DEC | * / , -
+// Day of week  | Yes        | 0-6 or SUN-SAT  | * / , - ? L #
+//
+// Day of month: L is the last day of the month, L-n the n-th day before it,
+// nW the weekday nearest to day n within the month, LW the last weekday.
+// Day of week: nL is the last day n of the month, n#k the k-th day n (1-5).
+// ? means the same as * and is meant for the day field which is not used.
+//
+// Shortcuts:
+//
+//	@yearly (@annually) 0 0 0 1 1 *
+//	@monthly            0 0 0 1 * *
+//	@weekly             0 0 0 * * 0
+//	@daily (@midnight)  0 0 0 * * *
+//	@hourly             0 0 * * * *
+//	@every <duration>   every duration (time.ParseDuration, whole seconds, at least 1s)
+//
+// The expression may start with TZ=<location> (e.g. "TZ=Asia/Shanghai 0 5 * * *"),
+// it is then evaluated in that location, see also CronExpr.In.
 type CronExpr struct {
 	sec   uint64
 	min   uint64
@@ -24,11 +41,84 @@ type CronExpr struct {
 	dom   uint64
 	month uint64
 	dow   uint64
+
+	// day of month
+	domLast        bool   // L
+	domLastOffset  int    // L-n
+	domLastWeekday bool   // LW
+	domWeekday     uint64 // nW
+
+	// day of week
+	dowLast uint64    // nL
+	dowNth  [7]uint64 // n#k, k 位
+
+	every time.Duration // @every
+	loc   *time.Location
+}
+
+var cronShortcuts = map[string]string{
+	"@yearly":   "0 0 0 1 1 *",
+	"@annually": "0 0 0 1 1 *",
+	"@monthly":  "0 0 0 1 * *",
+	"@weekly":   "0 0 0 * * 0",
+	"@daily":    "0 0 0 * * *",
+	"@midnight": "0 0 0 * * *",
+	"@hourly":   "0 0 * * * *",
+}
+
+var monthNames = map[string]int{
+	"JAN": 1, "FEB": 2, "MAR": 3, "APR": 4, "MAY": 5, "JUN": 6,
+	"JUL": 7, "AUG": 8, "SEP": 9, "OCT": 10, "NOV": 11, "DEC": 12,
+}
+
+var dowNames = map[string]int{
+	"SUN": 0, "MON": 1, "TUE": 2, "WED": 3, "THU": 4, "FRI": 5, "SAT": 6,
 }
 
 // goroutine safe
 func NewCronExpr(expr string) (cronExpr *CronExpr, err error) {
-	fields := strings.Fields(expr)
+	var loc *time.Location
+	spec := strings.TrimSpace(expr)
+	if strings.HasPrefix(spec, "TZ=") || strings.HasPrefix(spec, "CRON_TZ=") {
+		i := strings.IndexAny(spec, " \t")
+		if i < 0 {
+			err = fmt.Errorf("invalid expr %v: missing fields after time zone", expr)
+			return
+		}
+		name := spec[strings.Index(spec, "=")+1 : i]
+		loc, err = time.LoadLocation(name)
+		if err != nil {
+			err = fmt.Errorf("invalid expr %v: invalid time zone %v: %v", expr, name, err)
+			return
+		}
+		spec = strings.TrimSpace(spec[i:])
+	}
+
+	if strings.HasPrefix(spec, "@") {
+		if strings.HasPrefix(spec, "@every ") {
+			var d time.Duration
+			d, err = time.ParseDuration(strings.TrimSpace(spec[len("@every "):]))
+			if err != nil {
+				err = fmt.Errorf("invalid expr %v: %v", expr, err)
+				return
+			}
+			if d < time.Second || d%time.Second != 0 {
+				err = fmt.Errorf("invalid expr %v: @every requires whole seconds, at least 1s", expr)
+				return
+			}
+			cronExpr = &CronExpr{every: d, loc: loc}
+			return
+		}
+
+		s, ok := cronShortcuts[strings.ToLower(spec)]
+		if !ok {
+			err = fmt.Errorf("invalid expr %v: unknown shortcut %v", expr, spec)
+			return
+		}
+		spec = s
+	}
+
+	fields := strings.Fields(spec)
 	if len(fields) != 5 && len(fields) != 6 {
 		err = fmt.Errorf("invalid expr %v: expected 5 or 6 fields, got %v", expr, len(fields))
 		return
@@ -39,50 +129,144 @@ func NewCronExpr(expr string) (cronExpr *CronExpr, err error) {
 	}
 
 	cronExpr = new(CronExpr)
+	cronExpr.loc = loc
 	// Seconds
-	cronExpr.sec, err = parseCronField(fields[0], 0, 59)
+	cronExpr.sec, err = parseCronField(fields[0], 0, 59, nil)
 	if err != nil {
-		goto onError
+		err = fmt.Errorf("invalid expr %v: seconds: %v", expr, err)
+		return
 	}
 	// Minutes
-	cronExpr.min, err = parseCronField(fields[1], 0, 59)
+	cronExpr.min, err = parseCronField(fields[1], 0, 59, nil)
 	if err != nil {
-		goto onError
+		err = fmt.Errorf("invalid expr %v: minutes: %v", expr, err)
+		return
 	}
 	// Hours
-	cronExpr.hour, err = parseCronField(fields[2], 0, 23)
+	cronExpr.hour, err = parseCronField(fields[2], 0, 23, nil)
 	if err != nil {
-		goto onError
+		err = fmt.Errorf("invalid expr %v: hours: %v", expr, err)
+		return
 	}
 	// Day of month
-	cronExpr.dom, err = parseCronField(fields[3], 1, 31)
+	err = cronExpr.parseDom(fields[3])
 	if err != nil {
-		goto onError
+		err = fmt.Errorf("invalid expr %v: day of month: %v", expr, err)
+		return
 	}
 	// Month
-	cronExpr.month, err = parseCronField(fields[4], 1, 12)
+	cronExpr.month, err = parseCronField(fields[4], 1, 12, monthNames)
 	if err != nil {
-		goto onError
+		err = fmt.Errorf("invalid expr %v: month: %v", expr, err)
+		return
 	}
 	// Day of week
-	cronExpr.dow, err = parseCronField(fields[5], 0, 6)
+	err = cronExpr.parseDow(fields[5])
 	if err != nil {
-		goto onError
+		err = fmt.Errorf("invalid expr %v: day of week: %v", expr, err)
+		return
+	}
+	return
+}
+
+// In returns a copy of the expression evaluated in loc
+// goroutine safe
+func (e *CronExpr) In(loc *time.Location) *CronExpr {
+	c := *e
+	c.loc = loc
+	return &c
+}
+
+func (e *CronExpr) parseDom(field string) (err error) {
+	var rest []string
+	for _, part := range strings.Split(field, ",") {
+		switch {
+		case part == "?":
+			rest = append(rest, "*")
+		case part == "L":
+			e.domLast = true
+		case part == "LW":
+			e.domLastWeekday = true
+		case strings.HasPrefix(part, "L-"):
+			var n int
+			n, err = strconv.Atoi(part[2:])
+			if err != nil || n < 0 || n > 30 {
+				return fmt.Errorf("invalid last day offset: %v", part)
+			}
+			if e.domLast && e.domLastOffset != n {
+				return fmt.Errorf("more than one last day offset: %v", field)
+			}
+			e.domLast = true
+			e.domLastOffset = n
+		case strings.HasSuffix(part, "W"):
+			var n int
+			n, err = strconv.Atoi(part[:len(part)-1])
+			if err != nil || n < 1 || n > 31 {
+				return fmt.Errorf("invalid weekday: %v", part)
+			}
+			e.domWeekday |= 1 << uint(n)
+		default:
+			rest = append(rest, part)
+		}
+	}
+
+	if len(rest) > 0 {
+		e.dom, err = parseCronField(strings.Join(rest, ","), 1, 31, nil)
 	}
 	return
+}
 
-onError:
-	err = fmt.Errorf("invalid expr %v: %v", expr, err)
+func (e *CronExpr) parseDow(field string) (err error) {
+	var rest []string
+	for _, part := range strings.Split(field, ",") {
+		switch {
+		case part == "?":
+			rest = append(rest, "*")
+		case strings.Contains(part, "#"):
+			nk := strings.Split(part, "#")
+			var n, k int
+			n, err = parseCronValue(nk[0], dowNames)
+			if err != nil || len(nk) != 2 || n < 0 || n > 6 {
+				return fmt.Errorf("invalid nth day: %v", part)
+			}
+			k, err = strconv.Atoi(nk[1])
+			if err != nil || k < 1 || k > 5 {
+				return fmt.Errorf("invalid nth day: %v", part)
+			}
+			e.dowNth[n] |= 1 << uint(k)
+		case len(part) > 1 && strings.HasSuffix(part, "L"):
+			var n int
+			n, err = parseCronValue(part[:len(part)-1], dowNames)
+			if err != nil || n < 0 || n > 6 {
+				return fmt.Errorf("invalid last day: %v", part)
+			}
+			e.dowLast |= 1 << uint(n)
+		default:
+			rest = append(rest, part)
+		}
+	}
+
+	if len(rest) > 0 {
+		e.dow, err = parseCronField(strings.Join(rest, ","), 0, 6, dowNames)
+	}
 	return
 }
 
+// number or name (case-insensitive)
+func parseCronValue(s string, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToUpper(s)]; ok {
+		return v, nil
+	}
+	return strconv.Atoi(s)
+}
+
 // 1. *
 // 2. num
 // 3. num-num
 // 4. */num
 // 5. num/num (means num-max/num)
 // 6. num-num/num
-func parseCronField(field string, min int, max int) (cronField uint64, err error) {
+func parseCronField(field string, min int, max int, names map[string]int) (cronField uint64, err error) {
 	fields := strings.Split(field, ",")
 	for _, field := range fields {
 		rangeAndIncr := strings.Split(field, "/")
@@ -108,7 +292,7 @@ func parseCronField(field string, min int, max int) (cronField uint64, err error
 			end = max
 		} else {
 			// start
-			start, err = strconv.Atoi(startAndEnd[0])
+			start, err = parseCronValue(startAndEnd[0], names)
 			if err != nil {
 				err = fmt.Errorf("invalid range: %v", rangeAndIncr[0])
 				return
@@ -121,7 +305,7 @@ func parseCronField(field string, min int, max int) (cronField uint64, err error
 					end = start
 				}
 			} else {
-				end, err = strconv.Atoi(startAndEnd[1])
+				end, err = parseCronValue(startAndEnd[1], names)
 				if err != nil {
 					err = fmt.Errorf("invalid range: %v", rangeAndIncr[0])
 					return
@@ -171,23 +355,104 @@ func parseCronField(field string, min int, max int) (cronField uint64, err error
 	return
 }
 
+func (e *CronExpr) matchDom(t time.Time) bool {
+	day := t.Day()
+	if 1<<uint(day)&e.dom != 0 {
+		return true
+	}
+
+	lastDay := daysIn(t)
+	if e.domLast && day == lastDay-e.domLastOffset {
+		return true
+	}
+	if e.domLastWeekday && day == nearestWeekday(t, lastDay, lastDay) {
+		return true
+	}
+	if e.domWeekday != 0 {
+		for n := 1; n <= 31; n++ {
+			if 1<<uint(n)&e.domWeekday != 0 && day == nearestWeekday(t, n, lastDay) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (e *CronExpr) matchDow(t time.Time) bool {
+	weekday := uint(t.Weekday())
+	if 1<<weekday&e.dow != 0 {
+		return true
+	}
+
+	day := t.Day()
+	if 1<<weekday&e.dowLast != 0 && day+7 > daysIn(t) {
+		return true
+	}
+	return 1<<uint((day-1)/7+1)&e.dowNth[weekday] != 0
+}
+
 func (e *CronExpr) matchDay(t time.Time) bool {
+	domBlank := e.dom == 0xfffffffe && !e.domLast && !e.domLastWeekday && e.domWeekday == 0
+	dowBlank := e.dow == 0x7f && e.dowLast == 0 && e.dowNth == [7]uint64{}
+
 	// day-of-month blank
-	if e.dom == 0xfffffffe {
-		return 1<<uint(t.Weekday())&e.dow != 0
+	if domBlank {
+		return e.matchDow(t)
 	}
 
 	// day-of-week blank
-	if e.dow == 0x7f {
-		return 1<<uint(t.Day())&e.dom != 0
+	if dowBlank {
+		return e.matchDom(t)
+	}
+
+	return e.matchDow(t) || e.matchDom(t)
+}
+
+// 当月天数
+func daysIn(t time.Time) int {
+	return time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, t.Location()).Day()
+}
+
+// 当月离第 n 天最近的工作日, 不跨月
+func nearestWeekday(t time.Time, n int, lastDay int) int {
+	if n > lastDay {
+		n = lastDay
 	}
 
-	return 1<<uint(t.Weekday())&e.dow != 0 ||
-		1<<uint(t.Day())&e.dom != 0
+	switch time.Date(t.Year(), t.Month(), n, 0, 0, 0, 0, t.Location()).Weekday() {
+	case time.Saturday:
+		if n == 1 {
+			return 3
+		}
+		return n - 1
+	case time.Sunday:
+		if n == lastDay {
+			return n - 2
+		}
+		return n + 1
+	}
+	return n
 }
 
 // goroutine safe
 func (e *CronExpr) Next(t time.Time) time.Time {
+	if e.every > 0 {
+		return t.Add(e.every - time.Duration(t.Nanosecond()))
+	}
+
+	if e.loc != nil {
+		loc := t.Location()
+		next := e.next(t.In(e.loc))
+		if next.IsZero() {
+			return next
+		}
+		return next.In(loc)
+	}
+
+	return e.next(t)
+}
+
+func (e *CronExpr) next(t time.Time) time.Time {
 	// the upcoming second
 	t = t.Truncate(time.Second).Add(time.Second)
 
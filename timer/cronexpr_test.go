@@ -0,0 +1,89 @@
+package timer
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCronExprNext(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC) // Monday
+	tests := []struct {
+		expr string
+		next string
+	}{
+		{"0 0 12 * * MON-FRI", "2024-01-01 12:00:00"},
+		{"0 0 0 1 JUN ?", "2024-06-01 00:00:00"},
+		{"0 0 L * ?", "2024-01-31 00:00:00"},
+		{"0 0 L-2 2 ?", "2024-02-27 00:00:00"},
+		{"0 0 LW 3 ?", "2024-03-29 00:00:00"},    // 31st is Sunday
+		{"0 0 1W 6 ?", "2024-06-03 00:00:00"},    // 1st is Saturday
+		{"0 0 15W 9 ?", "2024-09-16 00:00:00"},   // 15th is Sunday
+		{"0 0 ? * 5L", "2024-01-26 00:00:00"},    // last Friday
+		{"0 0 ? * FRI#2", "2024-01-12 00:00:00"}, // second Friday
+		{"@daily", "2024-01-02 00:00:00"},
+		{"@hourly", "2024-01-01 01:00:00"},
+		{"@monthly", "2024-02-01 00:00:00"},
+		{"@every 90m", "2024-01-01 01:30:00"},
+	}
+
+	for _, tt := range tests {
+		e, err := NewCronExpr(tt.expr)
+		if err != nil {
+			t.Fatalf("%v: %v", tt.expr, err)
+		}
+		if next := e.Next(from).Format("2006-01-02 15:04:05"); next != tt.next {
+			t.Errorf("%v: next %v, want %v", tt.expr, next, tt.next)
+		}
+	}
+}
+
+func TestCronExprLocation(t *testing.T) {
+	loc := time.FixedZone("UTC+8", 8*3600)
+	from := time.Date(2023, 12, 31, 20, 0, 0, 0, time.UTC)
+	want := time.Date(2024, 1, 1, 5, 0, 0, 0, loc)
+
+	e, err := NewCronExpr("0 5 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := e.In(loc).Next(from)
+	if !next.Equal(want) || next.Location() != time.UTC {
+		t.Errorf("next %v, want %v", next, want)
+	}
+
+	e, err = NewCronExpr("TZ=Asia/Shanghai 0 5 * * *")
+	if err != nil {
+		t.Skip(err) // no tzdata
+	}
+	if next := e.Next(from); !next.Equal(want) {
+		t.Errorf("next %v, want %v", next, want)
+	}
+}
+
+func TestCronExprError(t *testing.T) {
+	tests := []struct {
+		expr string
+		err  string
+	}{
+		{"* * *", "expected 5 or 6 fields"},
+		{"0 60 * * * *", "minutes"},
+		{"0 24 * * *", "hours"},
+		{"0 0 32W * ?", "day of month"},
+		{"0 0 * FOO *", "month"},
+		{"0 0 ? * MON#6", "day of week"},
+		{"@weekdays", "unknown shortcut"},
+		{"@every 10ms", "at least 1s"},
+		{"@every 500ms", "at least 1s"},
+		{"@every 1500ms", "whole seconds"},
+		{"@every 1m0.5s", "whole seconds"},
+		{"TZ=Nowhere/City 0 0 * * *", "time zone"},
+	}
+
+	for _, tt := range tests {
+		_, err := NewCronExpr(tt.expr)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%v: err %v, want %q", tt.expr, err, tt.err)
+		}
+	}
+}
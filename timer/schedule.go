@@ -0,0 +1,254 @@
+package timer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/jiangzuomin/leaf/log"
+	"github.com/sirupsen/logrus"
+)
+
+// CatchUp is what a Scheduler does with the runs missed while the process was down
+type CatchUp int
+
+const (
+	CatchUpSkip CatchUp = iota // 忽略错过的触发
+	CatchUpOnce                // 只补一次 (最近一次错过的时间)
+	CatchUpAll                 // 每次都补, 最多 MaxCatchUp 次
+)
+
+// MaxCatchUp limits the runs fired by CatchUpAll
+var MaxCatchUp = 1000
+
+// CronStore persists the last run time of the scheduled jobs
+// goroutine safe
+type CronStore interface {
+	LastRun(name string) (t time.Time, ok bool, err error)
+	SetLastRun(name string, t time.Time) error
+}
+
+// FileStore is a CronStore keeping all jobs in one JSON file
+type FileStore struct {
+	path string
+	mu   sync.Mutex
+	runs map[string]time.Time
+}
+
+var (
+	mutexFileStores sync.Mutex
+	fileStores      = make(map[string]*FileStore)
+)
+
+// NewFileStore loads path, a missing file is an empty store. The stores of
+// the same path are shared in the process, so the jobs of different
+// schedulers do not overwrite each other.
+func NewFileStore(path string) (*FileStore, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	mutexFileStores.Lock()
+	defer mutexFileStores.Unlock()
+	if s, ok := fileStores[abs]; ok {
+		return s, nil
+	}
+
+	s, err := loadFileStore(abs)
+	if err != nil {
+		return nil, err
+	}
+	fileStores[abs] = s
+	return s, nil
+}
+
+func loadFileStore(path string) (*FileStore, error) {
+	s := &FileStore{path: path, runs: make(map[string]time.Time)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &s.runs); err != nil {
+			return nil, fmt.Errorf("cron store %v: %v", path, err)
+		}
+	}
+	return s, nil
+}
+
+func (s *FileStore) LastRun(name string) (time.Time, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.runs[name]
+	return t, ok, nil
+}
+
+func (s *FileStore) SetLastRun(name string, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.runs[name] = t
+	data, err := json.MarshalIndent(s.runs, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	// 先写临时文件再改名, 避免写一半时进程退出
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// Scheduler runs named cron jobs on a dispatcher and persists their last run,
+// so the runs missed while the process was down are detected at startup
+// goroutine not safe, use it on the goroutine of its dispatcher
+type Scheduler struct {
+	disp  *Dispatcher
+	store CronStore
+	jobs  map[string]*Job
+}
+
+// NewScheduler persists the last runs in store, if store is nil nothing is
+// persisted and no missed run is caught up
+func NewScheduler(disp *Dispatcher, store CronStore) *Scheduler {
+	return &Scheduler{disp: disp, store: store, jobs: make(map[string]*Job)}
+}
+
+// Job
+// goroutine not safe, use it on the goroutine of its dispatcher
+type Job struct {
+	name     string
+	cronExpr *CronExpr
+	cb       func(time.Time)
+	s        *Scheduler
+	t        *Timer
+	stopped  bool
+}
+
+// Schedule runs cb at the times of cronExpr, cb gets the scheduled time.
+// The first schedule of name only records the current time; afterwards the
+// runs between the stored last run and now are handled according to policy
+// before the job continues normally.
+func (s *Scheduler) Schedule(name string, cronExpr *CronExpr, policy CatchUp, cb func(time.Time)) (*Job, error) {
+	if _, ok := s.jobs[name]; ok {
+		return nil, fmt.Errorf("job %v already scheduled", name)
+	}
+
+	var last time.Time
+	var ok bool
+	if s.store != nil {
+		var err error
+		last, ok, err = s.store.LastRun(name)
+		if err != nil {
+			return nil, fmt.Errorf("job %v: %v", name, err)
+		}
+	}
+
+	j := &Job{name: name, cronExpr: cronExpr, cb: cb, s: s}
+	s.jobs[name] = j
+
+	now := s.disp.Now()
+	if !ok {
+		j.save(now)
+		j.next(now)
+		return j, nil
+	}
+
+	var missed []time.Time
+	for t := cronExpr.Next(last); !t.IsZero() && !t.After(now); t = cronExpr.Next(t) {
+		missed = append(missed, t)
+		if len(missed) > MaxCatchUp {
+			missed = missed[1:]
+		}
+	}
+	if len(missed) > 0 {
+		log.Log.WithFields(logrus.Fields{"job": name, "missed": len(missed), "policy": policy}).Info("cron job missed runs")
+	}
+
+	switch policy {
+	case CatchUpSkip:
+		if len(missed) > 0 {
+			j.save(now)
+			missed = nil
+		}
+	case CatchUpOnce:
+		if len(missed) > 0 {
+			missed = missed[len(missed)-1:]
+		}
+	}
+
+	if len(missed) == 0 {
+		j.next(now)
+		return j, nil
+	}
+
+	// 在 dispatcher 的 goroutine 上补触发, 先安排下次触发, 回调 panic 也不影响后续
+	j.t = s.disp.AfterFunc(0, func() {
+		j.next(s.disp.Now())
+		for _, t := range missed {
+			if j.stopped {
+				return
+			}
+			j.fire(t)
+		}
+	})
+	return j, nil
+}
+
+func (j *Job) next(now time.Time) {
+	nextTime := j.cronExpr.Next(now)
+	if nextTime.IsZero() {
+		return
+	}
+
+	j.t = j.s.disp.AfterFunc(nextTime.Sub(now), func() {
+		j.next(j.s.disp.Now())
+		j.fire(nextTime)
+	})
+}
+
+func (j *Job) fire(t time.Time) {
+	defer j.save(t)
+	j.cb(t)
+}
+
+func (j *Job) save(t time.Time) {
+	if j.s.store == nil {
+		return
+	}
+	if err := j.s.store.SetLastRun(j.name, t); err != nil {
+		log.Log.WithFields(logrus.Fields{"job": j.name, "error": err}).Error("save cron job last run")
+	}
+}
+
+func (j *Job) Stop() {
+	if j.stopped {
+		return
+	}
+	j.stopped = true
+	if j.t != nil {
+		j.t.Stop()
+	}
+	delete(j.s.jobs, j.name)
+}
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/jiangzuomin/leaf/config"
+	"github.com/jiangzuomin/leaf/internal/relay"
 	"github.com/jiangzuomin/leaf/log"
 	"github.com/sirupsen/logrus"
 )
@@ -12,23 +13,67 @@ import (
 // one dispatcher per goroutine (goroutine not safe)
 type Dispatcher struct {
 	ChanTimer chan *Timer
+	clock     Clock
+	relay     *relay.Relay[*Timer] // 时钟回调不能阻塞, 时间轮所有 dispatcher 共用一个 goroutine
 }
 
 func NewDispatcher(l int) *Dispatcher {
+	return NewClockDispatcher(l, SystemClock)
+}
+
+// NewClockDispatcher returns a dispatcher whose timers and crons use clock,
+// e.g. a FakeClock in tests
+func NewClockDispatcher(l int, clock Clock) *Dispatcher {
 	disp := new(Dispatcher)
 	disp.ChanTimer = make(chan *Timer, l)
+	disp.clock = clock
+	disp.relay = relay.New(disp.ChanTimer)
 	return disp
 }
 
+// NewWheelDispatcher returns a dispatcher whose timers are kept in wheel
+// instead of one runtime timer each, DefaultWheel() is used if wheel is nil
+func NewWheelDispatcher(l int, wheel *Wheel) *Dispatcher {
+	if wheel == nil {
+		wheel = DefaultWheel()
+	}
+
+	return NewClockDispatcher(l, wheel)
+}
+
+// Len returns the number of fired timers not yet received from ChanTimer
+// goroutine safe
+func (disp *Dispatcher) Len() int {
+	n := disp.relay.Len()
+	return n + len(disp.ChanTimer)
+}
+
+func (disp *Dispatcher) Now() time.Time {
+	return disp.clock.Now()
+}
+
 // Timer
+// goroutine not safe, use it on the goroutine of its dispatcher
 type Timer struct {
-	t  *time.Timer
+	t  Stopper
 	cb func()
+
+	disp     *Dispatcher
+	interval time.Duration // 大于 0 为重复定时器
+	when     time.Time     // 下次触发时间
+	remain   time.Duration // 暂停时的剩余时间
+	active   bool
+	paused   bool
+	gen      int // 每次调度递增, 之前已投递的触发被忽略
 }
 
 func (t *Timer) Stop() {
-	t.t.Stop()
-	t.cb = nil
+	if t.t != nil {
+		t.t.Stop()
+	}
+	t.active = false
+	t.paused = false
+	t.gen++
 }
 
 func (t *Timer) Cb() {
@@ -50,12 +95,120 @@ func (t *Timer) Cb() {
 	}
 }
 
-func (disp *Dispatcher) AfterFunc(d time.Duration, cb func()) *Timer {
+// 每次触发投递一个新的 *Timer 到 ChanTimer, 其回调检查调度代数,
+// 因此 Stop, Reset 和 Pause 之前已经投递的触发不会执行
+func (t *Timer) schedule(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+
+	t.gen++
+	gen := t.gen
+	t.when = t.disp.Now().Add(d)
+	t.active = true
+	t.paused = false
+
+	shot := &Timer{cb: func() {
+		t.onFire(gen)
+	}}
+	disp := t.disp
+	t.t = disp.clock.AfterFunc(d, func() {
+		disp.relay.Post(shot)
+	})
+}
+
+func (t *Timer) onFire(gen int) {
+	if gen != t.gen || !t.active {
+		return
+	}
+
+	if t.interval > 0 {
+		// 以计划时间为基准, 避免累积误差
+		t.schedule(t.when.Add(t.interval).Sub(t.disp.Now()))
+	} else {
+		t.active = false
+	}
+	if t.cb != nil {
+		t.cb()
+	}
+}
+
+// Reset changes the timer to expire after d (then every interval for a
+// repeating timer), a stopped or expired timer is activated again.
+// It returns true if the timer was active.
+func (t *Timer) Reset(d time.Duration) bool {
+	if t.disp == nil {
+		return false
+	}
+
+	active := t.active
+	if t.t != nil {
+		t.t.Stop()
+	}
+	t.schedule(d)
+	return active
+}
+
+// Remaining returns the time until the timer expires, 0 if it is not active
+func (t *Timer) Remaining() time.Duration {
+	if !t.active {
+		return 0
+	}
+	if t.paused {
+		return t.remain
+	}
+	if d := t.when.Sub(t.disp.Now()); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// Pause stops the countdown of an active timer until Resume
+func (t *Timer) Pause() {
+	if !t.active || t.paused {
+		return
+	}
+
+	t.remain = t.Remaining()
+	t.t.Stop()
+	t.gen++
+	t.paused = true
+}
+
+func (t *Timer) Resume() {
+	if !t.active || !t.paused {
+		return
+	}
+
+	t.schedule(t.remain)
+}
+
+func (t *Timer) Paused() bool {
+	return t.paused
+}
+
+func (disp *Dispatcher) newTimer(interval time.Duration, cb func()) *Timer {
 	t := new(Timer)
 	t.cb = cb
-	t.t = time.AfterFunc(d, func() {
-		disp.ChanTimer <- t
-	})
+	t.disp = disp
+	t.interval = interval
+	return t
+}
+
+func (disp *Dispatcher) AfterFunc(d time.Duration, cb func()) *Timer {
+	t := disp.newTimer(0, cb)
+	t.schedule(d)
+	return t
+}
+
+// TickFunc calls cb every interval until the timer is stopped
+func (disp *Dispatcher) TickFunc(interval time.Duration, cb func()) *Timer {
+	if interval <= 0 {
+		panic("non-positive interval for TickFunc")
+	}
+
+	t := disp.newTimer(interval, cb)
+	t.schedule(interval)
 	return t
 }
 
@@ -73,7 +226,7 @@ func (c *Cron) Stop() {
 func (disp *Dispatcher) CronFunc(cronExpr *CronExpr, _cb func()) *Cron {
 	c := new(Cron)
 
-	now := time.Now()
+	now := disp.Now()
 	nextTime := cronExpr.Next(now)
 	if nextTime.IsZero() {
 		return c
@@ -84,7 +237,7 @@ func (disp *Dispatcher) CronFunc(cronExpr *CronExpr, _cb func()) *Cron {
 	cb = func() {
 		defer _cb()
 
-		now := time.Now()
+		now := disp.Now()
 		nextTime := cronExpr.Next(now)
 		if nextTime.IsZero() {
 			return
@@ -95,3 +248,8 @@ func (disp *Dispatcher) CronFunc(cronExpr *CronExpr, _cb func()) *Cron {
 	c.t = disp.AfterFunc(nextTime.Sub(now), cb)
 	return c
 }
+
+// CronFuncIn evaluates cronExpr in loc instead of the location of disp.Now()
+func (disp *Dispatcher) CronFuncIn(cronExpr *CronExpr, loc *time.Location, cb func()) *Cron {
+	return disp.CronFunc(cronExpr.In(loc), cb)
+}
@@ -0,0 +1,266 @@
+package timer
+
+import (
+	"math/rand"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWheelDispatcher(t *testing.T) {
+	wheel := NewWheel(time.Millisecond)
+	defer wheel.Close()
+	disp := NewWheelDispatcher(10, wheel)
+
+	var fired []int
+	disp.AfterFunc(30*time.Millisecond, func() { fired = append(fired, 3) })
+	disp.AfterFunc(10*time.Millisecond, func() { fired = append(fired, 1) })
+	// beyond level 0, cascades from level 1
+	disp.AfterFunc(300*time.Millisecond, func() { fired = append(fired, 4) })
+	disp.AfterFunc(20*time.Millisecond, func() { fired = append(fired, 2) }).Stop()
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		(<-disp.ChanTimer).Cb()
+	}
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Fatalf("fired too early: %v", elapsed)
+	}
+
+	want := []int{1, 3, 4}
+	if len(fired) != len(want) {
+		t.Fatalf("expected %v, got %v", want, fired)
+	}
+	for i := range want {
+		if fired[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, fired)
+		}
+	}
+}
+
+func benchmarkAfterFuncStop(b *testing.B, disp *Dispatcher) {
+	cb := func() {}
+	for i := 0; i < b.N; i++ {
+		d := time.Duration(rand.Intn(60000)) * time.Millisecond
+		disp.AfterFunc(d, cb).Stop()
+	}
+}
+
+func BenchmarkAfterFuncStop(b *testing.B) {
+	benchmarkAfterFuncStop(b, NewDispatcher(0))
+}
+
+func BenchmarkWheelAfterFuncStop(b *testing.B) {
+	wheel := NewWheel(10 * time.Millisecond)
+	defer wheel.Close()
+	benchmarkAfterFuncStop(b, NewWheelDispatcher(0, wheel))
+}
+
+// b.N timers expiring within 100 milliseconds, including the delivery
+func benchmarkFire(b *testing.B, disp *Dispatcher) {
+	cb := func() {}
+	go func() {
+		for i := 0; i < b.N; i++ {
+			d := time.Duration(rand.Intn(100)) * time.Millisecond
+			disp.AfterFunc(d, cb)
+		}
+	}()
+	for i := 0; i < b.N; i++ {
+		(<-disp.ChanTimer).Cb()
+	}
+}
+
+func BenchmarkFire(b *testing.B) {
+	benchmarkFire(b, NewDispatcher(1024))
+}
+
+func BenchmarkWheelFire(b *testing.B) {
+	wheel := NewWheel(time.Millisecond)
+	defer wheel.Close()
+	benchmarkFire(b, NewWheelDispatcher(1024, wheel))
+}
+
+func TestTickResetPause(t *testing.T) {
+	disp := NewDispatcher(10)
+
+	var ticks int
+	tick := disp.TickFunc(10*time.Millisecond, func() { ticks++ })
+	for ticks < 3 {
+		(<-disp.ChanTimer).Cb()
+	}
+	tick.Stop()
+
+	var fired bool
+	timer := disp.AfterFunc(time.Hour, func() { fired = true })
+	if r := timer.Remaining(); r <= 59*time.Minute {
+		t.Fatalf("unexpected remaining %v", r)
+	}
+
+	timer.Pause()
+	remain := timer.Remaining()
+	time.Sleep(10 * time.Millisecond)
+	if !timer.Paused() || timer.Remaining() != remain {
+		t.Fatal("paused timer should not count down")
+	}
+	timer.Resume()
+
+	if !timer.Reset(10 * time.Millisecond) {
+		t.Fatal("timer should be active")
+	}
+	(<-disp.ChanTimer).Cb()
+	if !fired || timer.Remaining() != 0 {
+		t.Fatal("timer should have fired")
+	}
+
+	// a stopped timer can be reset, firings delivered before Stop are ignored
+	fired = false
+	timer.Reset(0)
+	time.Sleep(10 * time.Millisecond)
+	timer.Stop()
+	(<-disp.ChanTimer).Cb()
+	if fired {
+		t.Fatal("stopped timer should not fire")
+	}
+	if timer.Reset(time.Millisecond) {
+		t.Fatal("stopped timer should not be active")
+	}
+	(<-disp.ChanTimer).Cb()
+	if !fired {
+		t.Fatal("reset timer should fire")
+	}
+}
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2024, 1, 1, 4, 59, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	disp := NewClockDispatcher(10, clock)
+
+	cronExpr, err := NewCronExpr("0 5 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var resets []time.Time
+	disp.CronFunc(cronExpr, func() { resets = append(resets, disp.Now()) })
+
+	var ticks int
+	disp.TickFunc(30*time.Minute, func() { ticks++ })
+
+	for i := 0; i < 48*2; i++ {
+		clock.Advance(30 * time.Second)
+		for len(disp.ChanTimer) > 0 {
+			(<-disp.ChanTimer).Cb()
+		}
+	}
+
+	if len(resets) != 1 || !resets[0].Equal(start.Add(time.Minute)) {
+		t.Fatalf("unexpected resets %v", resets)
+	}
+	if ticks != 1 {
+		t.Fatalf("expected 1 tick, got %v", ticks)
+	}
+
+	for i := 0; i < 48; i++ {
+		clock.Advance(30 * time.Minute)
+		for len(disp.ChanTimer) > 0 {
+			(<-disp.ChanTimer).Cb()
+		}
+	}
+	if len(resets) != 2 || ticks != 49 {
+		t.Fatalf("unexpected resets %v, ticks %v", resets, ticks)
+	}
+}
+
+func TestSchedulerCatchUp(t *testing.T) {
+	cronExpr, err := NewCronExpr("0 5 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "cron.json")
+
+	run := func(now time.Time, policy CatchUp) []time.Time {
+		clock := NewFakeClock(now)
+		disp := NewClockDispatcher(10, clock)
+		// a new process, not the store shared by NewFileStore
+		store, err := loadFileStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var runs []time.Time
+		_, err = NewScheduler(disp, store).Schedule("reset", cronExpr, policy, func(t time.Time) { runs = append(runs, t) })
+		if err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(0)
+		for len(disp.ChanTimer) > 0 {
+			(<-disp.ChanTimer).Cb()
+		}
+		return runs
+	}
+
+	day := time.Date(2024, 1, 1, 4, 50, 0, 0, time.UTC)
+	if runs := run(day, CatchUpAll); len(runs) != 0 {
+		t.Fatalf("first start: unexpected runs %v", runs)
+	}
+	// down from 04:50 to 05:10
+	if runs := run(day.Add(20*time.Minute), CatchUpOnce); len(runs) != 1 || runs[0].Hour() != 5 {
+		t.Fatalf("once: unexpected runs %v", runs)
+	}
+	if runs := run(day.Add(72*time.Hour), CatchUpAll); len(runs) != 2 {
+		t.Fatalf("all: unexpected runs %v", runs)
+	}
+	if runs := run(day.Add(120*time.Hour), CatchUpSkip); len(runs) != 0 {
+		t.Fatalf("skip: unexpected runs %v", runs)
+	}
+	if runs := run(day.Add(144*time.Hour), CatchUpOnce); len(runs) != 1 || runs[0].Day() != 6 {
+		t.Fatalf("once after skip: unexpected runs %v", runs)
+	}
+}
+
+func TestWheelSharedDispatchers(t *testing.T) {
+	wheel := NewWheel(time.Millisecond)
+	defer wheel.Close()
+
+	// busy never drains its ChanTimer
+	busy := NewWheelDispatcher(1, wheel)
+	for i := 0; i < 5; i++ {
+		busy.AfterFunc(time.Millisecond, func() {})
+	}
+	disp := NewWheelDispatcher(1, wheel)
+	disp.AfterFunc(20*time.Millisecond, func() {})
+
+	select {
+	case <-disp.ChanTimer:
+	case <-time.After(2 * time.Second):
+		t.Fatal("a backed up dispatcher stalled the wheel")
+	}
+	if len(busy.ChanTimer) != 1 {
+		t.Fatalf("busy dispatcher: %v timers delivered", len(busy.ChanTimer))
+	}
+	for i := 0; i < 5; i++ {
+		<-busy.ChanTimer
+	}
+}
+
+func TestSchedulerNoStore(t *testing.T) {
+	cronExpr, err := NewCronExpr("0 5 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := NewFakeClock(time.Date(2024, 1, 1, 4, 50, 0, 0, time.UTC))
+	disp := NewClockDispatcher(10, clock)
+
+	var runs []time.Time
+	_, err = NewScheduler(disp, nil).Schedule("reset", cronExpr, CatchUpAll, func(t time.Time) { runs = append(runs, t) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(20 * time.Minute)
+	for len(disp.ChanTimer) > 0 {
+		(<-disp.ChanTimer).Cb()
+	}
+	if len(runs) != 1 || runs[0].Hour() != 5 {
+		t.Fatalf("unexpected runs %v", runs)
+	}
+}
@@ -0,0 +1,193 @@
+package timer
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Wheel is a Clock backed by a hierarchical timing wheel driven by a single ticker. Level 0 has
+// wheelSize0 slots of one tick, each upper level has wheelSize slots covering
+// a whole turn of the level below; timers are moved down a level when their
+// slot is reached and fire from level 0. The precision is one tick.
+// goroutine safe
+type Wheel struct {
+	sync.Mutex
+	tickDur  time.Duration
+	start    time.Time
+	current  int64 // 已处理到的 tick
+	levels   []*wheelLevel
+	closeSig chan bool
+}
+
+type wheelLevel struct {
+	tick    int64 // 每个槽的 tick 数
+	buckets []*list.List
+}
+
+type wheelEntry struct {
+	wheel      *Wheel
+	expiration int64 // 到期的 tick
+	f          func()
+	bucket     *list.List
+	elem       *list.Element
+}
+
+const (
+	wheelSize0  = 256
+	wheelSize   = 64
+	wheelLevels = 5
+)
+
+var (
+	defaultWheel     *Wheel
+	defaultWheelOnce sync.Once
+)
+
+// DefaultWheel returns the wheel shared by NewWheelDispatcher(l, nil),
+// its tick is 10 milliseconds
+func DefaultWheel() *Wheel {
+	defaultWheelOnce.Do(func() {
+		defaultWheel = NewWheel(10 * time.Millisecond)
+	})
+	return defaultWheel
+}
+
+func NewWheel(tick time.Duration) *Wheel {
+	if tick <= 0 {
+		tick = 10 * time.Millisecond
+	}
+
+	w := new(Wheel)
+	w.tickDur = tick
+	w.start = time.Now()
+	w.closeSig = make(chan bool)
+
+	var levelTick int64 = 1
+	for i := 0; i < wheelLevels; i++ {
+		size := wheelSize
+		if i == 0 {
+			size = wheelSize0
+		}
+		level := &wheelLevel{tick: levelTick, buckets: make([]*list.List, size)}
+		for j := range level.buckets {
+			level.buckets[j] = list.New()
+		}
+		w.levels = append(w.levels, level)
+		levelTick *= int64(size)
+	}
+
+	go w.run()
+	return w
+}
+
+// Close stops the ticker, pending timers never fire
+func (w *Wheel) Close() {
+	close(w.closeSig)
+}
+
+func (w *Wheel) Now() time.Time {
+	return time.Now()
+}
+
+// AfterFunc calls f on the wheel goroutine after at least d, f must not block
+// for long since it delays all the other timers of the wheel
+func (w *Wheel) AfterFunc(d time.Duration, f func()) Stopper {
+	w.Lock()
+	defer w.Unlock()
+
+	// 向上取整, 至少一个 tick
+	ticks := int64((time.Since(w.start) + d + w.tickDur - 1) / w.tickDur)
+	if ticks <= w.current {
+		ticks = w.current + 1
+	}
+
+	e := &wheelEntry{wheel: w, expiration: ticks, f: f}
+	w.add(e)
+	return e
+}
+
+// Stop prevents the entry from firing, returns false if it already fired or
+// was stopped
+func (e *wheelEntry) Stop() bool {
+	w := e.wheel
+	w.Lock()
+	defer w.Unlock()
+
+	if e.bucket == nil {
+		return false
+	}
+	e.bucket.Remove(e.elem)
+	e.bucket = nil
+	e.elem = nil
+	return true
+}
+
+// 需持有锁, 已到期返回 false
+func (w *Wheel) add(e *wheelEntry) bool {
+	delay := e.expiration - w.current
+	if delay <= 0 {
+		return false
+	}
+
+	for i, level := range w.levels {
+		size := int64(len(level.buckets))
+		if delay < level.tick*size || i == len(w.levels)-1 {
+			b := level.buckets[(e.expiration/level.tick)%size]
+			e.bucket = b
+			e.elem = b.PushBack(e)
+			return true
+		}
+	}
+	panic("bug")
+}
+
+func (w *Wheel) run() {
+	ticker := time.NewTicker(w.tickDur)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.closeSig:
+			return
+		case now := <-ticker.C:
+			w.advance(int64(now.Sub(w.start) / w.tickDur))
+		}
+	}
+}
+
+// 逐 tick 推进到 target, 上层到达槽位起点时把其中的条目降到下层
+func (w *Wheel) advance(target int64) {
+	for {
+		w.Lock()
+		if w.current >= target {
+			w.Unlock()
+			return
+		}
+		w.current++
+
+		var expired []func()
+		for i := len(w.levels) - 1; i >= 0; i-- {
+			level := w.levels[i]
+			if w.current%level.tick != 0 {
+				continue
+			}
+
+			size := int64(len(level.buckets))
+			b := level.buckets[(w.current/level.tick)%size]
+			for b.Len() > 0 {
+				e := b.Remove(b.Front()).(*wheelEntry)
+				e.bucket = nil
+				e.elem = nil
+				if !w.add(e) {
+					expired = append(expired, e.f)
+				}
+			}
+		}
+		w.Unlock()
+
+		for _, f := range expired {
+			f()
+		}
+	}
+}